@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"log"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/breezechen/go_file_server/webdav/server"
+)
+
+// tempFilePrefix 上传、下载和 WebDAV 写入时临时文件的前缀，这些文件不在目录列表中显示，启动时被清理
+const tempFilePrefix = server.TempFilePrefix
+
+// isTempFile 判断文件名是否为写入中的临时文件
+func isTempFile(name string) bool {
+	return strings.HasPrefix(name, tempFilePrefix)
+}
+
+// writeFileAtomic 通过 write 将内容写入 dst 所在目录的临时文件，成功后重命名为 dst，
+// 读取 dst 的用户不会看到写了一半的文件，失败时删除临时文件并保留原来的 dst
+func writeFileAtomic(dst string, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(dst), tempFilePrefix+"*")
+	if err != nil {
+		return err
+	}
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	// CreateTemp 创建的文件只有所有者可读，改为与直接创建文件相同的权限
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// saveUploadedFile 将上传的文件保存到 dst，返回写入的字节数和 SHA-256
+func saveUploadedFile(file *multipart.FileHeader, dst string) (int64, string, error) {
+	src, err := file.Open()
+	if err != nil {
+		return 0, "", err
+	}
+	defer src.Close()
+	var n int64
+	hash := sha256.New()
+	err = writeFileAtomic(dst, func(w io.Writer) error {
+		n, err = io.Copy(io.MultiWriter(w, hash), src)
+		return err
+	})
+	if err != nil {
+		return 0, "", err
+	}
+	return n, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// taskTempDir 返回下载任务写入文件时使用的临时目录，位于目标目录中，完成后文件移动到目标目录
+func taskTempDir(dir, taskId string) string {
+	return filepath.Join(dir, tempFilePrefix+taskId)
+}
+
+// cleanTempFiles 删除 root 下之前的运行中断后残留的临时文件和下载任务的临时目录，
+// keep 中的路径（仍然保留的任务的临时目录）不会被删除，返回删除的数量
+func cleanTempFiles(root string, keep map[string]bool) int {
+	removed := 0
+	filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || p == root || !isTempFile(d.Name()) {
+			return nil
+		}
+		if keep[p] {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if err := os.RemoveAll(p); err != nil {
+			log.Printf("failed to remove temporary file %s: %v", p, err)
+		} else {
+			removed++
+		}
+		if d.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return removed
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestWriteFileAtomic 测试写入失败时保留原来的文件，临时文件不出现在目录列表中并在启动时被清理
+func TestWriteFileAtomic(t *testing.T) {
+	root := t.TempDir()
+	target := filepath.Join(root, "a.txt")
+	os.WriteFile(target, []byte("old"), 0644)
+
+	err := writeFileAtomic(target, func(w io.Writer) error {
+		io.WriteString(w, "partial")
+		return errors.New("connection reset")
+	})
+	if err == nil {
+		t.Fatal("writeFileAtomic should return the write error")
+	}
+	if data, _ := os.ReadFile(target); string(data) != "old" {
+		t.Errorf("failed write replaced the file: %q", data)
+	}
+
+	if err := writeFileAtomic(target, func(w io.Writer) error {
+		_, err := io.WriteString(w, "new")
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(target); string(data) != "new" {
+		t.Errorf("file = %q, want new", data)
+	}
+	if entries, _ := os.ReadDir(root); len(entries) != 1 {
+		t.Errorf("temporary files left behind: %v", entries)
+	}
+
+	// 模拟中断的上传和下载任务
+	os.WriteFile(filepath.Join(root, tempFilePrefix+"123"), []byte("x"), 0644)
+	os.MkdirAll(taskTempDir(filepath.Join(root, "sub"), "task"), 0755)
+	os.WriteFile(filepath.Join(taskTempDir(filepath.Join(root, "sub"), "task"), "b.bin"), []byte("x"), 0644)
+
+	for _, item := range genJson(root, "/") {
+		if isTempFile(item["name"].(string)) {
+			t.Errorf("listing should hide %s", item["name"])
+		}
+	}
+	// 暂停的任务的临时目录保留
+	paused := taskTempDir(root, "paused")
+	os.MkdirAll(paused, 0755)
+	os.WriteFile(filepath.Join(paused, "c.bin"), []byte("x"), 0644)
+
+	if removed := cleanTempFiles(root, map[string]bool{paused: true}); removed != 2 {
+		t.Errorf("cleanTempFiles() = %d, want 2", removed)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(root, "sub")); len(entries) != 0 {
+		t.Errorf("task temporary directory should be removed: %v", entries)
+	}
+	if _, err := os.Stat(filepath.Join(paused, "c.bin")); err != nil {
+		t.Errorf("temporary directory of a kept task should not be removed: %v", err)
+	}
+}
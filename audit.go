@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/breezechen/go_file_server/auth"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// auditKey 处理函数通过 gin.Context 传给审计中间件的操作信息的键
+	auditKey = "audit"
+	// 查询审计日志默认和最多返回的记录数
+	defaultAuditLimit = 100
+	maxAuditLimit     = 10000
+)
+
+// AuditEntry 审计日志中的一条记录，对应一次修改文件的请求
+type AuditEntry struct {
+	Time time.Time `json:"time"`
+	// 请求的用户，匿名时为空
+	User string `json:"user,omitempty"`
+	IP   string `json:"ip"`
+	// 操作：upload、createDir、deleteFile、download 等 JSON 方法，或 PUT、DELETE 等 WebDAV 方法
+	Method string `json:"method"`
+	// 请求访问的路径，WebDAV 请求为去掉 /$.dav$ 前缀后的路径
+	Path string `json:"path"`
+	// MOVE、COPY 的目标路径，或下载任务的来源 URL
+	Target string `json:"target,omitempty"`
+	// 上传的文件名
+	Files  []string `json:"files,omitempty"`
+	Status int      `json:"status"`
+	// 根据状态码得出的结果：success、denied 或 failed
+	Outcome string `json:"outcome"`
+}
+
+// AuditFilter 查询审计日志的条件，零值表示不限制
+type AuditFilter struct {
+	User string
+	// 路径前缀，匹配该路径本身和其下的路径
+	Path  string
+	Since time.Time
+	Until time.Time
+	// 最多返回的记录数
+	Limit int
+}
+
+type ListAuditResponse struct {
+	Entries []*AuditEntry `json:"entries"`
+}
+
+// AuditLog 将审计记录以 JSON Lines 格式追加到文件中，文件超过 maxSize 后轮转为 .1、.2 …，最多保留 maxFiles 个旧文件
+type AuditLog struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewAuditLog 打开审计日志文件，maxSize 为 0 时不轮转
+func NewAuditLog(path string, maxSize int64, maxFiles int) (*AuditLog, error) {
+	if maxSize < 0 || maxFiles < 0 {
+		return nil, fmt.Errorf("audit log size and file count must not be negative")
+	}
+	l := &AuditLog{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := l.openLocked(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// openLocked 打开日志文件用于追加，调用方需持有 l.mu
+func (l *AuditLog) openLocked() error {
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	l.file = file
+	l.size = stat.Size()
+	return nil
+}
+
+// rotatedPath 返回第 n 个旧日志文件的路径
+func (l *AuditLog) rotatedPath(n int) string {
+	return l.path + "." + strconv.Itoa(n)
+}
+
+// rotateLocked 将当前文件改名为 .1，已有的旧文件依次后移，超出 maxFiles 的被删除，调用方需持有 l.mu。
+// 改名失败时继续追加到原来的文件
+func (l *AuditLog) rotateLocked() error {
+	l.file.Close()
+	err := l.shiftLocked()
+	if openErr := l.openLocked(); openErr != nil {
+		return openErr
+	}
+	return err
+}
+
+// shiftLocked 移动日志文件为轮转腾出位置，调用方需持有 l.mu
+func (l *AuditLog) shiftLocked() error {
+	if l.maxFiles == 0 {
+		return os.Remove(l.path)
+	}
+	os.Remove(l.rotatedPath(l.maxFiles))
+	for n := l.maxFiles - 1; n >= 1; n-- {
+		if err := os.Rename(l.rotatedPath(n), l.rotatedPath(n+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(l.path, l.rotatedPath(1))
+}
+
+// Record 写入一条审计记录
+func (l *AuditLog) Record(entry *AuditEntry) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("failed to encode audit entry: %v", err)
+		return
+	}
+	data = append(data, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(data)) > l.maxSize {
+		if err := l.rotateLocked(); err != nil {
+			log.Printf("failed to rotate audit log: %v", err)
+		}
+	}
+	n, err := l.file.Write(data)
+	l.size += int64(n)
+	if err != nil {
+		log.Printf("failed to write audit log: %v", err)
+	}
+}
+
+// Query 从当前和轮转的日志文件中查找符合条件的记录，按时间从新到旧返回
+func (l *AuditLog) Query(filter AuditFilter) ([]*AuditEntry, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultAuditLimit
+	}
+	limit = min(limit, maxAuditLimit)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entries := make([]*AuditEntry, 0)
+	files := []string{l.path}
+	for n := 1; n <= l.maxFiles; n++ {
+		files = append(files, l.rotatedPath(n))
+	}
+	// 从最新的文件开始读，取够 limit 条后停止
+	for _, file := range files {
+		matched, err := readAuditFile(file, filter)
+		if err != nil {
+			return nil, err
+		}
+		for i := len(matched) - 1; i >= 0 && len(entries) < limit; i-- {
+			entries = append(entries, matched[i])
+		}
+		if len(entries) >= limit {
+			break
+		}
+	}
+	return entries, nil
+}
+
+// readAuditFile 读取日志文件中符合条件的记录，文件不存在时返回空列表，无法解析的行被忽略
+func readAuditFile(file string, filter AuditFilter) ([]*AuditEntry, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []*AuditEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		if filter.match(&entry) {
+			entries = append(entries, &entry)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// match 判断记录是否符合条件
+func (f AuditFilter) match(entry *AuditEntry) bool {
+	if f.User != "" && entry.User != f.User {
+		return false
+	}
+	if f.Path != "" && !isSubPath(f.Path, entry.Path) && !isSubPath(f.Path, entry.Target) {
+		return false
+	}
+	if !f.Since.IsZero() && entry.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && entry.Time.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+// isSubPath 判断 p 是否为 prefix 本身或其下的路径
+func isSubPath(prefix, p string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return p == prefix || strings.HasPrefix(p, prefix+"/")
+}
+
+// Close 关闭日志文件
+func (l *AuditLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// auditOutcome 根据状态码得出请求的结果
+func auditOutcome(status int) string {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden || status == http.StatusTooManyRequests:
+		return "denied"
+	case status >= 400:
+		return "failed"
+	default:
+		return "success"
+	}
+}
+
+// setAudit 记录请求的操作，审计中间件在请求结束后写入日志
+func setAudit(c *gin.Context, method, path string) *AuditEntry {
+	entry := &AuditEntry{Method: method, Path: path}
+	c.Set(auditKey, entry)
+	return entry
+}
+
+// isWebDAVMutation 判断是否为修改文件的 WebDAV 方法
+func isWebDAVMutation(method string) bool {
+	switch method {
+	case http.MethodPut, http.MethodDelete, "MOVE", "COPY", "MKCOL":
+		return true
+	}
+	return false
+}
+
+// redactURL 去掉 URL 中的用户名和密码
+func redactURL(rawUrl string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil || u.User == nil {
+		return rawUrl
+	}
+	u.User = nil
+	return u.String()
+}
+
+// auditMiddleware 在修改文件的请求结束后写入审计日志，包括被认证拒绝的请求。
+// 处理函数通过 setAudit 提供操作信息，WebDAV 和未识别出操作的 POST 请求按 HTTP 方法记录
+func auditMiddleware(auditLog *AuditLog) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// WebDAV 中间件会去掉路径前缀，需要先记录原始路径
+		requestPath := c.Request.URL.Path
+		method := c.Request.Method
+		c.Next()
+
+		var entry *AuditEntry
+		if value, ok := c.Get(auditKey); ok {
+			entry = value.(*AuditEntry)
+		} else if davPath, ok := strings.CutPrefix(requestPath, "/$.dav$"); ok && isWebDAVMutation(method) {
+			if davPath == "" {
+				davPath = "/"
+			}
+			entry = &AuditEntry{Method: method, Path: davPath}
+			if method == "MOVE" || method == "COPY" {
+				entry.Target = webdavDestination(c.Request)
+			}
+		} else if method == http.MethodPost && !strings.HasPrefix(requestPath, "/:") {
+			entry = &AuditEntry{Method: method, Path: requestPath}
+		} else {
+			return
+		}
+
+		entry.Time = time.Now()
+		entry.User = auth.UserFromRequest(c.Request)
+		entry.IP = c.ClientIP()
+		entry.Status = c.Writer.Status()
+		entry.Outcome = auditOutcome(entry.Status)
+		auditLog.Record(entry)
+	}
+}
+
+// handleAuditQuery 返回符合查询参数 user、path、since、until（RFC 3339 格式）和 limit 的审计记录，只有管理员可以查询
+func handleAuditQuery(c *gin.Context, authConfig *auth.AuthConfig, auditLog *AuditLog) {
+	if authConfig == nil || !authConfig.IsAdmin(auth.UserFromRequest(c.Request)) || !auth.HasScope(c.Request, auth.ScopeRead) {
+		c.String(403, "403 forbidden")
+		return
+	}
+	if auditLog == nil {
+		c.String(404, "audit log is not enabled")
+		return
+	}
+
+	filter := AuditFilter{
+		User: c.Query("user"),
+		Path: c.Query("path"),
+	}
+	var err error
+	for name, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := c.Query(name); value != "" {
+			if *t, err = time.Parse(time.RFC3339, value); err != nil {
+				c.String(400, "invalid %s: %v", name, err)
+				return
+			}
+		}
+	}
+	if value := c.Query("limit"); value != "" {
+		if filter.Limit, err = strconv.Atoi(value); err != nil || filter.Limit < 0 {
+			c.String(400, "invalid limit: %s", value)
+			return
+		}
+	}
+
+	entries, err := auditLog.Query(filter)
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	c.JSON(200, ListAuditResponse{Entries: entries})
+}
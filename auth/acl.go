@@ -0,0 +1,160 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// Decision 访问控制的结果
+type Decision int
+
+const (
+	// Allow 允许访问
+	Allow Decision = iota
+	// NeedAuth 匿名用户无权访问，登录后可能有权限
+	NeedAuth
+	// Forbidden 当前用户无权访问，或路径被禁止访问
+	Forbidden
+)
+
+// StatusCode 返回拒绝访问时对应的 HTTP 状态码，允许访问时为 0
+func (d Decision) StatusCode() int {
+	switch d {
+	case NeedAuth:
+		return http.StatusUnauthorized
+	case Forbidden:
+		return http.StatusForbidden
+	default:
+		return 0
+	}
+}
+
+// ACL 按路径的访问控制规则，从 JSON 配置文件加载，例如：
+//
+//	{
+//	  "groups": {"team-a": ["alice", "bob"]},
+//	  "rules": [
+//	    {"path": "/public/**", "read": ["anonymous"], "write": ["authenticated"]},
+//	    {"path": "/team-a/**", "write": ["group:team-a"]},
+//	    {"path": "/admin/**", "deny": true}
+//	  ]
+//	}
+//
+// 规则按顺序匹配，第一条匹配的规则生效，没有匹配的规则时按 AuthConfig 的读写权限模式判断
+type ACL struct {
+	// Groups 组名到用户名列表的映射
+	Groups map[string][]string `json:"groups"`
+	Rules  []*Rule             `json:"rules"`
+}
+
+// Rule 一条访问控制规则
+type Rule struct {
+	// Path 路径模式，* 匹配一级路径中的任意字符，** 匹配任意多级路径（包括零级）
+	Path string `json:"path"`
+	// Read、Write 为允许读、写的主体：anonymous（所有人，包括未登录用户）、authenticated（已登录用户）、
+	// group:<组名> 或用户名，允许写的主体同时允许读
+	Read  []string `json:"read"`
+	Write []string `json:"write"`
+	// Deny 为 true 时禁止所有人访问
+	Deny bool `json:"deny"`
+}
+
+// LoadACL 加载访问控制配置文件
+func LoadACL(file string) (*ACL, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	acl := &ACL{}
+	if err := json.Unmarshal(data, acl); err != nil {
+		return nil, fmt.Errorf("invalid acl file %s: %w", file, err)
+	}
+	for i, rule := range acl.Rules {
+		if rule == nil || !strings.HasPrefix(rule.Path, "/") {
+			return nil, fmt.Errorf("invalid acl file %s: rule %d must have an absolute path", file, i+1)
+		}
+		for _, segment := range strings.Split(rule.Path, "/") {
+			if _, err := path.Match(segment, ""); err != nil {
+				return nil, fmt.Errorf("invalid acl file %s: rule %d: bad pattern %s", file, i+1, rule.Path)
+			}
+		}
+	}
+	return acl, nil
+}
+
+// match 返回第一条匹配 p 的规则，没有时返回 nil
+func (acl *ACL) match(p string) *Rule {
+	p = path.Clean("/" + p)
+	for _, rule := range acl.Rules {
+		if matchPath(strings.Split(rule.Path, "/"), strings.Split(p, "/")) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// inGroup 判断用户是否属于组
+func (acl *ACL) inGroup(username, group string) bool {
+	for _, member := range acl.Groups[group] {
+		if member == username {
+			return true
+		}
+	}
+	return false
+}
+
+// decide 按规则判断用户能否读或写，username 为空表示匿名
+func (acl *ACL) decide(rule *Rule, write bool, username string) Decision {
+	if rule.Deny {
+		return Forbidden
+	}
+
+	subjects := rule.Write
+	if !write {
+		subjects = append(append([]string(nil), rule.Read...), rule.Write...)
+	}
+	for _, subject := range subjects {
+		switch {
+		case subject == "anonymous":
+			return Allow
+		case username == "":
+			continue
+		case subject == "authenticated" || subject == username:
+			return Allow
+		case strings.HasPrefix(subject, "group:") && acl.inGroup(username, strings.TrimPrefix(subject, "group:")):
+			return Allow
+		}
+	}
+
+	if username == "" {
+		return NeedAuth
+	}
+	return Forbidden
+}
+
+// matchPath 逐级匹配路径，** 匹配零级或多级
+func matchPath(pattern, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(segments); i++ {
+				if matchPath(pattern[1:], segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segments) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+			return false
+		}
+		pattern, segments = pattern[1:], segments[1:]
+	}
+	return len(segments) == 0
+}
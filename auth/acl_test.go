@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestACL 测试按路径的访问控制规则
+func TestACL(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "acl.json")
+	os.WriteFile(file, []byte(`{
+		"groups": {"team-a": ["alice"]},
+		"rules": [
+			{"path": "/public/**", "read": ["anonymous"], "write": ["authenticated"]},
+			{"path": "/team-a/**", "write": ["group:team-a"]},
+			{"path": "/admin/**", "deny": true},
+			{"path": "/home/*/notes.txt", "read": ["bob"]}
+		]
+	}`), 0644)
+	acl, err := LoadACL(file)
+	if err != nil {
+		t.Fatalf("LoadACL failed: %v", err)
+	}
+
+	a := NewAuthConfig("bob", "secret")
+	a.SetACL(acl)
+	tests := []struct {
+		method, path, username string
+		want                   Decision
+	}{
+		{"GET", "/public/a/b.txt", "", Allow},
+		{"POST", "/public", "", NeedAuth},
+		{"PUT", "/public/x", "bob", Allow},
+		{"GET", "/team-a", "", NeedAuth},
+		{"GET", "/team-a/doc", "bob", Forbidden},
+		{"MKCOL", "/team-a/new", "alice", Allow},
+		{"GET", "/admin", "bob", Forbidden},
+		{"GET", "/admin/../public/x", "", Allow},
+		{"GET", "/home/u/notes.txt", "bob", Allow},
+		{"GET", "/home/u/v/notes.txt", "", Allow},
+		// 没有匹配的规则时按读写权限模式判断
+		{"GET", "/other", "", Allow},
+		{"DELETE", "/other", "", NeedAuth},
+		{"DELETE", "/other", "bob", Allow},
+	}
+	for _, tt := range tests {
+		if got := a.Authorize(tt.method, tt.path, tt.username); got != tt.want {
+			t.Errorf("Authorize(%s, %s, %q) = %v, want %v", tt.method, tt.path, tt.username, got, tt.want)
+		}
+	}
+
+	handler := a.HTTPMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(UserFromRequest(r)))
+	}))
+	for _, tt := range []struct {
+		path, username, password string
+		want                     int
+	}{
+		{"/team-a/doc", "", "", http.StatusUnauthorized},
+		{"/team-a/doc", "bob", "secret", http.StatusForbidden},
+		{"/team-a/doc", "bob", "wrong", http.StatusUnauthorized},
+		{"/public/doc", "bob", "secret", http.StatusOK},
+	} {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		if tt.username != "" {
+			req.SetBasicAuth(tt.username, tt.password)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("GET %s as %q: status = %d, want %d", tt.path, tt.username, rec.Code, tt.want)
+		}
+		if rec.Code == http.StatusOK && rec.Body.String() != tt.username {
+			t.Errorf("GET %s: user = %q, want %q", tt.path, rec.Body.String(), tt.username)
+		}
+	}
+
+	os.WriteFile(file, []byte(`{"rules": [{"path": "relative/**"}]}`), 0644)
+	if _, err := LoadACL(file); err == nil {
+		t.Error("LoadACL should reject relative paths")
+	}
+}
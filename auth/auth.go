@@ -1,8 +1,10 @@
 package auth
 
 import (
+	"context"
 	"crypto/subtle"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
@@ -30,6 +32,54 @@ type AuthConfig struct {
 	WritePermission PermissionMode
 	// Realm for basic auth
 	Realm string
+	// 用户文件中的账号，与 Username/Password 同时生效
+	Users *UserStore
+	// 按路径的访问控制规则，没有匹配的规则时使用 ReadPermission 和 WritePermission
+	ACL *ACL
+	// API 令牌，通过 Authorization: Bearer 头使用
+	Tokens *TokenStore
+	// 访问这些路径的令牌需要的权限范围，其它路径读操作需要 read，写操作需要 write
+	PathScopes map[string]string
+	// 网页登录的会话，为空时只能使用基础认证和 API 令牌
+	Sessions *SessionStore
+	// 认证失败次数限制，为空时不限制
+	Limiter *LoginLimiter
+	// 管理员用户，可以查看和解除认证失败的锁定
+	Admins []string
+	// 是否将已验证的 TLS 客户端证书识别为用户，用户名取自证书主题
+	ClientCerts bool
+}
+
+// identity 认证识别出的请求者
+type identity struct {
+	username string
+	// 通过 API 令牌认证时不为空
+	token *Token
+	// 通过网页登录的会话认证时不为空
+	session *Session
+}
+
+// identityContextKey 请求 context 中保存请求者的键
+type identityContextKey struct{}
+
+// identityFromRequest 返回中间件识别出的请求者，匿名或未经过中间件时为 nil
+func identityFromRequest(r *http.Request) *identity {
+	id, _ := r.Context().Value(identityContextKey{}).(*identity)
+	return id
+}
+
+// UserFromRequest 返回中间件识别出的用户名，匿名时为空
+func UserFromRequest(r *http.Request) string {
+	if id := identityFromRequest(r); id != nil {
+		return id.username
+	}
+	return ""
+}
+
+// HasScope 判断请求是否有 scope 权限，只有 API 令牌受权限范围的限制
+func HasScope(r *http.Request, scope string) bool {
+	id := identityFromRequest(r)
+	return id == nil || id.token == nil || id.token.HasScope(scope)
 }
 
 // NewAuthConfig 创建默认认证配置
@@ -43,6 +93,58 @@ func NewAuthConfig(username, password string) *AuthConfig {
 	}
 }
 
+// SetUserStore 设置用户文件中的账号，用户被删除或修改密码后其会话失效
+func (a *AuthConfig) SetUserStore(users *UserStore) {
+	a.Users = users
+	users.OnChange(a.revokeSessions)
+}
+
+// SetTokenStore 设置 API 令牌
+func (a *AuthConfig) SetTokenStore(tokens *TokenStore) {
+	a.Tokens = tokens
+}
+
+// SetSessionStore 设置网页登录的会话
+func (a *AuthConfig) SetSessionStore(sessions *SessionStore) {
+	a.Sessions = sessions
+}
+
+// SetLoginLimiter 设置认证失败次数限制
+func (a *AuthConfig) SetLoginLimiter(limiter *LoginLimiter) {
+	a.Limiter = limiter
+}
+
+// SetAdmins 设置管理员用户
+func (a *AuthConfig) SetAdmins(admins []string) {
+	a.Admins = admins
+}
+
+// SetPathScope 设置令牌访问 path 时需要的权限范围
+func (a *AuthConfig) SetPathScope(path, scope string) {
+	if a.PathScopes == nil {
+		a.PathScopes = make(map[string]string)
+	}
+	a.PathScopes[path] = scope
+}
+
+// SetACL 设置按路径的访问控制规则
+func (a *AuthConfig) SetACL(acl *ACL) {
+	a.ACL = acl
+}
+
+// HomeDir 返回用户在用户文件中配置的主目录，没有配置时为空
+func (a *AuthConfig) HomeDir(username string) string {
+	if a.Users == nil {
+		return ""
+	}
+	return a.Users.Home(username)
+}
+
+// enabled 判断是否配置了任何账号
+func (a *AuthConfig) enabled() bool {
+	return (a.Username != "" && a.Password != "") || a.Users != nil || a.Tokens != nil || a.ClientCerts
+}
+
 // SetReadPermission 设置读权限
 func (a *AuthConfig) SetReadPermission(requireAuth bool) {
 	if requireAuth {
@@ -61,32 +163,152 @@ func (a *AuthConfig) SetWritePermission(requireAuth bool) {
 	}
 }
 
-// IsAuthRequired 检查是否需要认证
+// IsAuthRequired 检查匿名用户能否以 method 访问 path
 func (a *AuthConfig) IsAuthRequired(method string, path string) bool {
-	// 如果没有设置用户名密码，不需要认证
-	if a.Username == "" || a.Password == "" {
-		return false
+	return a.Authorize(method, path, "") != Allow
+}
+
+// Authorize 判断用户能否以 method 访问 path，username 为空表示匿名
+func (a *AuthConfig) Authorize(method, path, username string) Decision {
+	write := isWriteMethod(method)
+	if a.ACL != nil {
+		if rule := a.ACL.match(path); rule != nil {
+			return a.ACL.decide(rule, write, username)
+		}
 	}
 
-	// 根据HTTP方法判断是读还是写操作
+	// 如果没有设置任何账号，不需要认证
+	if !a.enabled() || username != "" {
+		return Allow
+	}
+	mode := a.WritePermission
 	switch method {
-	case http.MethodGet, http.MethodHead, http.MethodOptions:
-		// 读操作
-		return a.ReadPermission == PermissionRequired
 	case http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodPatch:
-		// 写操作
-		return a.WritePermission == PermissionRequired
 	default:
-		// WebDAV特殊方法
-		if isWebDAVWriteMethod(method) {
-			return a.WritePermission == PermissionRequired
+		if !write {
+			mode = a.ReadPermission
+		} else if !isWebDAVWriteMethod(method) {
+			// 未知的方法默认需要认证
+			mode = PermissionRequired
 		}
-		if isWebDAVReadMethod(method) {
-			return a.ReadPermission == PermissionRequired
+	}
+	if mode == PermissionRequired {
+		return NeedAuth
+	}
+	return Allow
+}
+
+// Check 识别请求的用户并判断能否访问 path，返回带有请求者信息的请求（UserFromRequest、HasScope 使用）
+// 和拒绝访问时应返回的状态码（允许时为 0），认证失败次数过多被锁定时返回 429
+func (a *AuthConfig) Check(r *http.Request, path string) (*http.Request, int) {
+	id, err := a.authenticate(r)
+	if err != nil {
+		return r, http.StatusTooManyRequests
+	}
+	if id == nil {
+		return r, a.Authorize(r.Method, path, "").StatusCode()
+	}
+	r = r.WithContext(context.WithValue(r.Context(), identityContextKey{}, id))
+	if id.token != nil && !a.tokenAllowed(id.token, r.Method, path) {
+		return r, http.StatusForbidden
+	}
+	// 浏览器会自动带上会话 Cookie，写请求需要 CSRF 令牌证明来自本站的页面
+	if id.session != nil && isWriteMethod(r.Method) && !id.session.validCSRF(r) {
+		return r, http.StatusForbidden
+	}
+	return r, a.Authorize(r.Method, path, id.username).StatusCode()
+}
+
+// tokenAllowed 判断令牌的权限范围能否以 method 访问 path
+func (a *AuthConfig) tokenAllowed(token *Token, method, path string) bool {
+	if scope, ok := a.PathScopes[path]; ok {
+		return token.HasScope(scope)
+	}
+	if !isWriteMethod(method) {
+		return token.HasScope(ScopeRead)
+	}
+	// POST 请求还用于下载任务等 API，由处理函数通过 HasScope 检查具体的权限
+	if method == http.MethodPost && token.HasScope(ScopeDownloadTasks) {
+		return true
+	}
+	return token.HasScope(ScopeWrite)
+}
+
+// Reject 以 Check 返回的状态码拒绝请求 r。401 时一般要求客户端进行基础认证，
+// 启用了网页登录时浏览器的页面访问返回登录页面，网页脚本的请求只返回状态码。429 时告知客户端剩余的锁定时间
+func (a *AuthConfig) Reject(w http.ResponseWriter, r *http.Request, status int) {
+	if status == http.StatusTooManyRequests {
+		username, _, _ := r.BasicAuth()
+		w.Header().Set("Retry-After", strconv.Itoa(a.retryAfter(r, username)))
+	}
+	if status == http.StatusUnauthorized {
+		if a.Sessions != nil && wantsLoginPage(r) {
+			a.serveLoginPage(w, status, r.URL.RequestURI(), "")
+			return
 		}
-		// 默认需要认证
+		if a.Sessions == nil || !isXHR(r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="`+a.Realm+`"`)
+		}
+	}
+	w.WriteHeader(status)
+}
+
+// authenticate 从请求中识别用户，依次使用 API 令牌、基础认证、会话 Cookie 和 TLS 客户端证书，没有凭据或凭据错误时视为匿名，返回 nil。
+// 错误的令牌和密码计入失败次数，被锁定时返回 ErrLockedOut
+func (a *AuthConfig) authenticate(r *http.Request) (*identity, error) {
+	if raw, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		if a.Tokens == nil {
+			return nil, nil
+		}
+		if a.Limiter != nil && a.Limiter.Locked(limiterKeys(r, "")...) > 0 {
+			return nil, ErrLockedOut
+		}
+		if token := a.Tokens.Authenticate(strings.TrimSpace(raw)); token != nil && a.tokenUserExists(token.User) {
+			return &identity{username: token.User, token: token}, nil
+		}
+		if a.Limiter != nil {
+			a.Limiter.Fail(limiterKeys(r, "")...)
+		}
+		return nil, nil
+	}
+
+	username, password, hasAuth := r.BasicAuth()
+	if !hasAuth {
+		if _, session := a.sessionFromRequest(r); session != nil {
+			return &identity{username: session.Username, session: session}, nil
+		}
+		if username := a.certUser(r); username != "" {
+			return &identity{username: username}, nil
+		}
+		return nil, nil
+	}
+	if !a.enabled() {
+		return nil, nil
+	}
+	ok, err := a.verifyCredentials(r, username, password)
+	if !ok {
+		return nil, err
+	}
+	return &identity{username: username}, nil
+}
+
+// tokenUserExists 判断令牌所属的用户是否存在，配置了用户文件时必须是其中的用户或 --username 指定的用户，
+// 用户被删除后其令牌随之失效
+func (a *AuthConfig) tokenUserExists(username string) bool {
+	if a.Users == nil {
 		return true
 	}
+	return (a.Username != "" && username == a.Username) || a.Users.Exists(username)
+}
+
+// isWriteMethod 判断是否为写操作，未知的方法按写操作处理
+func isWriteMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	default:
+		return !isWebDAVReadMethod(method)
+	}
 }
 
 // isWebDAVReadMethod 判断是否为WebDAV读方法
@@ -113,32 +335,29 @@ func isWebDAVWriteMethod(method string) bool {
 
 // ValidateCredentials 验证凭据
 func (a *AuthConfig) ValidateCredentials(username, password string) bool {
-	if a.Username == "" || a.Password == "" {
+	if !a.enabled() {
 		return true // 未配置认证
 	}
-	
-	usernameMatch := subtle.ConstantTimeCompare([]byte(username), []byte(a.Username)) == 1
-	passwordMatch := subtle.ConstantTimeCompare([]byte(password), []byte(a.Password)) == 1
-	
-	return usernameMatch && passwordMatch
+
+	if a.Username != "" && a.Password != "" {
+		usernameMatch := subtle.ConstantTimeCompare([]byte(username), []byte(a.Username)) == 1
+		passwordMatch := subtle.ConstantTimeCompare([]byte(password), []byte(a.Password)) == 1
+		if usernameMatch && passwordMatch {
+			return true
+		}
+	}
+	return a.Users != nil && a.Users.Authenticate(username, password)
 }
 
 // GinMiddleware 为Gin创建认证中间件
 func (a *AuthConfig) GinMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// 检查是否需要认证
-		if !a.IsAuthRequired(c.Request.Method, c.Request.URL.Path) {
-			c.Next()
-			return
-		}
-
-		// 获取Basic Auth凭据
-		username, password, hasAuth := c.Request.BasicAuth()
-		
-		if !hasAuth || !a.ValidateCredentials(username, password) {
-			// 要求认证
-			c.Header("WWW-Authenticate", `Basic realm="`+a.Realm+`"`)
-			c.AbortWithStatus(http.StatusUnauthorized)
+		req, status := a.Check(c.Request, c.Request.URL.Path)
+		// 被拒绝时也保留识别出的用户，供之后的中间件（例如审计日志）使用
+		c.Request = req
+		if status != 0 {
+			a.Reject(c.Writer, c.Request, status)
+			c.Abort()
 			return
 		}
 
@@ -149,53 +368,64 @@ func (a *AuthConfig) GinMiddleware() gin.HandlerFunc {
 // HTTPMiddleware 为标准HTTP Handler创建认证中间件
 func (a *AuthConfig) HTTPMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// 检查是否需要认证
-		if !a.IsAuthRequired(r.Method, r.URL.Path) {
-			next.ServeHTTP(w, r)
+		req, status := a.Check(r, r.URL.Path)
+		if status != 0 {
+			a.Reject(w, r, status)
 			return
 		}
 
-		// 获取Basic Auth凭据
-		username, password, ok := r.BasicAuth()
-		
-		if !ok || !a.ValidateCredentials(username, password) {
-			// 要求认证
-			w.Header().Set("WWW-Authenticate", `Basic realm="`+a.Realm+`"`)
-			w.WriteHeader(http.StatusUnauthorized)
-			return
-		}
-
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, req)
 	})
 }
 
 // CheckAPIAuth 检查API请求的认证（用于AJAX请求）
 func (a *AuthConfig) CheckAPIAuth(c *gin.Context) bool {
+	ok, _ := a.checkAPIAuth(c)
+	return ok
+}
+
+// checkAPIAuth 检查API请求的认证，认证失败次数过多被锁定时返回 ErrLockedOut
+func (a *AuthConfig) checkAPIAuth(c *gin.Context) (bool, error) {
 	// API请求通常是写操作
 	if a.WritePermission != PermissionRequired {
-		return true
+		return true, nil
 	}
 
 	// 检查Basic Auth
 	username, password, hasAuth := c.Request.BasicAuth()
-	if hasAuth && a.ValidateCredentials(username, password) {
-		return true
+	if hasAuth {
+		if ok, err := a.verifyCredentials(c.Request, username, password); ok || err != nil {
+			return ok, err
+		}
 	}
 
 	// 也可以支持通过Header传递认证信息（用于AJAX）
 	headerUser := c.GetHeader("X-Auth-User")
 	headerPass := c.GetHeader("X-Auth-Pass")
 	if headerUser != "" && headerPass != "" {
-		return a.ValidateCredentials(headerUser, headerPass)
+		return a.verifyCredentials(c.Request, headerUser, headerPass)
 	}
 
-	return false
+	return false, nil
 }
 
 // RequireAPIAuth 要求API认证的中间件（用于特定的API路由）
 func (a *AuthConfig) RequireAPIAuth() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if !a.CheckAPIAuth(c) {
+		ok, err := a.checkAPIAuth(c)
+		if err != nil {
+			username, _, hasAuth := c.Request.BasicAuth()
+			if !hasAuth {
+				username = c.GetHeader("X-Auth-User")
+			}
+			c.Header("Retry-After", strconv.Itoa(a.retryAfter(c.Request, username)))
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error": err.Error(),
+			})
+			c.Abort()
+			return
+		}
+		if !ok {
 			c.JSON(http.StatusUnauthorized, gin.H{
 				"error": "Authentication required",
 			})
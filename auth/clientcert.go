@@ -0,0 +1,30 @@
+package auth
+
+import (
+	"crypto/x509"
+	"net/http"
+)
+
+// SetClientCertAuth 设置是否将已验证的 TLS 客户端证书识别为用户
+func (a *AuthConfig) SetClientCertAuth(enabled bool) {
+	a.ClientCerts = enabled
+}
+
+// ClientCertUser 返回客户端证书对应的用户名：证书主题的 CommonName，没有时使用第一个邮件地址
+func ClientCertUser(cert *x509.Certificate) string {
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName
+	}
+	if len(cert.EmailAddresses) > 0 {
+		return cert.EmailAddresses[0]
+	}
+	return ""
+}
+
+// certUser 返回请求的连接上经过 CA 验证的客户端证书对应的用户名，没有时为空
+func (a *AuthConfig) certUser(r *http.Request) string {
+	if !a.ClientCerts || r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return ClientCertUser(r.TLS.VerifiedChains[0][0])
+}
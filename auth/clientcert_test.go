@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestClientCertAuth 测试已验证的客户端证书识别为用户
+func TestClientCertAuth(t *testing.T) {
+	config := NewAuthConfig("", "")
+	config.SetClientCertAuth(true)
+
+	alice := &x509.Certificate{Subject: pkix.Name{CommonName: "alice"}}
+	bob := &x509.Certificate{EmailAddresses: []string{"bob@example.com"}}
+	tests := []struct {
+		name  string
+		state *tls.ConnectionState
+		want  int
+	}{
+		{"no TLS", nil, 401},
+		{"no certificate", &tls.ConnectionState{}, 401},
+		{"unverified certificate", &tls.ConnectionState{PeerCertificates: []*x509.Certificate{alice}}, 401},
+		{"common name", &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{alice}}}, 0},
+		{"email address", &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{bob}}}, 0},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/docs", nil)
+		req.TLS = tt.state
+		req, status := config.Check(req, "/docs")
+		if status != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, status, tt.want)
+		}
+		if status == 0 && UserFromRequest(req) != ClientCertUser(tt.state.VerifiedChains[0][0]) {
+			t.Errorf("%s: user = %q", tt.name, UserFromRequest(req))
+		}
+	}
+
+	// 请求中的凭据优先于客户端证书
+	config.Username, config.Password = "admin", "secret"
+	req := httptest.NewRequest("POST", "/docs", nil)
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{alice}}}
+	req.SetBasicAuth("admin", "secret")
+	if req, status := config.Check(req, "/docs"); status != 0 || UserFromRequest(req) != "admin" {
+		t.Errorf("basic auth should take precedence, got %d %q", status, UserFromRequest(req))
+	}
+
+	// 未启用时忽略客户端证书
+	config.SetClientCertAuth(false)
+	req = httptest.NewRequest("POST", "/docs", nil)
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{alice}}}
+	if _, status := config.Check(req, "/docs"); status != 401 {
+		t.Errorf("client certificates should be ignored when disabled, got %d", status)
+	}
+}
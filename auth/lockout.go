@@ -0,0 +1,248 @@
+package auth
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrLockedOut 客户端或用户认证失败次数过多，暂时被锁定
+var ErrLockedOut = errors.New("too many failed login attempts")
+
+// Lockout 被暂时锁定的客户端 IP（ip:<地址>）或用户名（user:<用户名>）
+type Lockout struct {
+	Key         string    `json:"key"`
+	Failures    int       `json:"failures"`
+	LockedUntil time.Time `json:"lockedUntil"`
+}
+
+// attempts 一个 IP 或用户名的认证失败记录
+type attempts struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// LoginLimiter 按客户端 IP 和用户名记录认证失败次数，连续失败 MaxFailures 次后锁定，
+// 之后每次失败锁定时间加倍，最长为 MaxLockout
+type LoginLimiter struct {
+	MaxFailures int
+	// 第一次锁定的时间
+	Lockout time.Duration
+	// 最长锁定时间，最后一次失败超过该时间后失败次数清零
+	MaxLockout time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*attempts
+}
+
+// NewLoginLimiter 创建认证失败限制，连续失败 maxFailures 次后锁定 lockout，最长锁定 1 小时
+func NewLoginLimiter(maxFailures int, lockout time.Duration) *LoginLimiter {
+	maxLockout := time.Hour
+	if lockout > maxLockout {
+		maxLockout = lockout
+	}
+	return &LoginLimiter{
+		MaxFailures: maxFailures,
+		Lockout:     lockout,
+		MaxLockout:  maxLockout,
+		entries:     make(map[string]*attempts),
+	}
+}
+
+// limiterKeys 返回请求和用户名对应的记录键，用户名为空时只按 IP 记录
+func limiterKeys(r *http.Request, username string) []string {
+	keys := []string{"ip:" + clientIP(r)}
+	if username != "" {
+		keys = append(keys, "user:"+username)
+	}
+	return keys
+}
+
+// clientIP 返回请求的客户端 IP
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Locked 返回 keys 中任一记录剩余的锁定时间，没有被锁定时返回 0
+func (l *LoginLimiter) Locked(keys ...string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	var wait time.Duration
+	for _, key := range keys {
+		if entry := l.entries[key]; entry != nil && entry.lockedUntil.After(now) {
+			wait = max(wait, entry.lockedUntil.Sub(now))
+		}
+	}
+	return wait
+}
+
+// Fail 记录一次认证失败，达到次数后锁定
+func (l *LoginLimiter) Fail(keys ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.pruneLocked(now)
+	for _, key := range keys {
+		entry := l.entries[key]
+		if entry == nil {
+			entry = &attempts{}
+			l.entries[key] = entry
+		}
+		entry.failures++
+		entry.lastFailure = now
+		if entry.failures >= l.MaxFailures {
+			lockout := l.Lockout
+			for i := l.MaxFailures; i < entry.failures && lockout < l.MaxLockout; i++ {
+				lockout *= 2
+			}
+			entry.lockedUntil = now.Add(min(lockout, l.MaxLockout))
+		}
+	}
+}
+
+// Succeed 认证成功后清除用户名的失败记录，IP 的记录保留，避免用一个有效账号解除对其它账号的猜测限制
+func (l *LoginLimiter) Succeed(username string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.entries, "user:"+username)
+}
+
+// pruneLocked 删除最后一次失败超过 MaxLockout 且没有被锁定的记录，调用方需持有 l.mu
+func (l *LoginLimiter) pruneLocked(now time.Time) {
+	for key, entry := range l.entries {
+		if now.Sub(entry.lastFailure) > l.MaxLockout && !entry.lockedUntil.After(now) {
+			delete(l.entries, key)
+		}
+	}
+}
+
+// Lockouts 返回当前被锁定的 IP 和用户名，按解除时间排序
+func (l *LoginLimiter) Lockouts() []Lockout {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	lockouts := make([]Lockout, 0)
+	for key, entry := range l.entries {
+		if entry.lockedUntil.After(now) {
+			lockouts = append(lockouts, Lockout{Key: key, Failures: entry.failures, LockedUntil: entry.lockedUntil})
+		}
+	}
+	sort.Slice(lockouts, func(i, j int) bool {
+		return lockouts[i].LockedUntil.Before(lockouts[j].LockedUntil)
+	})
+	return lockouts
+}
+
+// Clear 清除 key 的失败记录，key 为空时清除所有记录，返回是否清除了记录
+func (l *LoginLimiter) Clear(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if key == "" {
+		cleared := len(l.entries) > 0
+		l.entries = make(map[string]*attempts)
+		return cleared
+	}
+	if _, ok := l.entries[key]; !ok {
+		return false
+	}
+	delete(l.entries, key)
+	return true
+}
+
+// verifyCredentials 验证用户名和密码并记录失败次数，客户端 IP 或用户名被锁定时不验证，返回 ErrLockedOut
+func (a *AuthConfig) verifyCredentials(r *http.Request, username, password string) (bool, error) {
+	if a.Limiter == nil {
+		return a.ValidateCredentials(username, password), nil
+	}
+	keys := limiterKeys(r, username)
+	if a.Limiter.Locked(keys...) > 0 {
+		return false, ErrLockedOut
+	}
+	if !a.ValidateCredentials(username, password) {
+		a.Limiter.Fail(keys...)
+		return false, nil
+	}
+	a.Limiter.Succeed(username)
+	return true, nil
+}
+
+// Verify 用 verify 检查请求携带的密码并记录失败次数，subject 为密码保护的对象（例如 share:<ID>），
+// 失败次数同时计入客户端 IP 和 subject。被锁定时不检查，返回 ErrLockedOut 和剩余的锁定时间
+func (l *LoginLimiter) Verify(r *http.Request, subject string, verify func() bool) (bool, time.Duration, error) {
+	keys := []string{"ip:" + clientIP(r), subject}
+	if l == nil {
+		return verify(), 0, nil
+	}
+	if wait := l.Locked(keys...); wait > 0 {
+		return false, wait, ErrLockedOut
+	}
+	if !verify() {
+		l.Fail(keys...)
+		return false, 0, nil
+	}
+	return true, 0, nil
+}
+
+// retryAfter 返回请求的客户端 IP 或 username 被锁定的剩余秒数，至少为 1
+func (a *AuthConfig) retryAfter(r *http.Request, username string) int {
+	if a.Limiter == nil {
+		return 1
+	}
+	return max(1, int(a.Limiter.Locked(limiterKeys(r, username)...).Round(time.Second).Seconds()))
+}
+
+// IsAdmin 判断用户是否为管理员
+func (a *AuthConfig) IsAdmin(username string) bool {
+	for _, admin := range a.Admins {
+		if username != "" && admin == username {
+			return true
+		}
+	}
+	return false
+}
+
+// ServeLockouts 供管理员查看（GET）和解除（DELETE，key 参数为空时解除所有）当前的锁定，需要经过认证中间件
+func (a *AuthConfig) ServeLockouts(w http.ResponseWriter, r *http.Request) {
+	username := UserFromRequest(r)
+	if username == "" {
+		a.Reject(w, r, http.StatusUnauthorized)
+		return
+	}
+	if !a.IsAdmin(username) || !HasScope(r, ScopeWrite) {
+		http.Error(w, "403 forbidden", http.StatusForbidden)
+		return
+	}
+	if a.Limiter == nil {
+		writeJSON(w, http.StatusOK, map[string][]Lockout{"lockouts": {}})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string][]Lockout{"lockouts": a.Limiter.Lockouts()})
+	case http.MethodDelete:
+		key := strings.TrimSpace(r.URL.Query().Get("key"))
+		if !a.Limiter.Clear(key) && key != "" {
+			http.Error(w, "404 lockout not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		http.Error(w, "405 method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestLoginLimiter 测试认证失败后的锁定和解除
+func TestLoginLimiter(t *testing.T) {
+	l := NewLoginLimiter(3, time.Minute)
+	for i := 0; i < 2; i++ {
+		l.Fail("user:bob")
+	}
+	if l.Locked("user:bob") != 0 {
+		t.Fatal("should not be locked before reaching max failures")
+	}
+	l.Fail("user:bob")
+	if wait := l.Locked("ip:1.2.3.4", "user:bob"); wait <= 0 || wait > time.Minute {
+		t.Fatalf("first lockout = %v, want about 1m", wait)
+	}
+	// 之后每次失败锁定时间加倍
+	l.Fail("user:bob")
+	if wait := l.Locked("user:bob"); wait <= time.Minute || wait > 2*time.Minute {
+		t.Errorf("second lockout = %v, want about 2m", wait)
+	}
+	for i := 0; i < 10; i++ {
+		l.Fail("user:bob")
+	}
+	if wait := l.Locked("user:bob"); wait > time.Hour {
+		t.Errorf("lockout = %v, should not exceed 1h", wait)
+	}
+
+	lockouts := l.Lockouts()
+	if len(lockouts) != 1 || lockouts[0].Key != "user:bob" || lockouts[0].Failures != 14 {
+		t.Fatalf("Lockouts() = %+v", lockouts)
+	}
+	if l.Clear("user:alice") {
+		t.Error("Clear should report missing entries")
+	}
+	if !l.Clear("user:bob") || l.Locked("user:bob") != 0 {
+		t.Error("Clear should remove the lockout")
+	}
+}
+
+// TestLockout 测试中间件对猜测密码的客户端的锁定
+func TestLockout(t *testing.T) {
+	a := NewAuthConfig("bob", "secret")
+	a.SetReadPermission(true)
+	a.SetLoginLimiter(NewLoginLimiter(3, time.Minute))
+	a.SetAdmins([]string{"bob"})
+	handler := a.HTTPMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/:lockouts" {
+			a.ServeLockouts(w, r)
+			return
+		}
+		w.Write([]byte(UserFromRequest(r)))
+	}))
+	do := func(method, path, remoteAddr, username, password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = remoteAddr
+		req.SetBasicAuth(username, password)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := 0; i < 3; i++ {
+		if rec := do("GET", "/", "10.0.0.1:1000", "bob", "wrong"); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: status = %d, want 401", i, rec.Code)
+		}
+	}
+	// 锁定后正确的密码也被拒绝
+	rec := do("GET", "/", "10.0.0.1:1000", "bob", "secret")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("locked client: status = %d, Retry-After = %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	// 用户名也被锁定，换 IP 也不能登录
+	if rec := do("GET", "/", "10.0.0.2:1000", "bob", "secret"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("locked username: status = %d, want 429", rec.Code)
+	}
+
+	a.Limiter.Clear("user:bob")
+	rec = do("GET", "/:lockouts", "10.0.0.2:1000", "bob", "secret")
+	var resp struct {
+		Lockouts []Lockout `json:"lockouts"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if rec.Code != http.StatusOK || len(resp.Lockouts) != 1 || resp.Lockouts[0].Key != "ip:10.0.0.1" {
+		t.Fatalf("list lockouts: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if rec := do("DELETE", "/:lockouts?key=ip:10.0.0.1", "10.0.0.2:1000", "bob", "secret"); rec.Code != http.StatusNoContent {
+		t.Fatalf("clear lockout: status = %d", rec.Code)
+	}
+	if rec := do("GET", "/", "10.0.0.1:1000", "bob", "secret"); rec.Code != http.StatusOK {
+		t.Errorf("after clearing: status = %d, want 200", rec.Code)
+	}
+
+	// 只有管理员可以管理锁定
+	a.SetAdmins(nil)
+	if rec := do("GET", "/:lockouts", "10.0.0.2:1000", "bob", "secret"); rec.Code != http.StatusForbidden {
+		t.Errorf("non-admin list lockouts: status = %d, want 403", rec.Code)
+	}
+}
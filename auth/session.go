@@ -0,0 +1,324 @@
+package auth
+
+import (
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"html/template"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// SessionCookieName 保存会话 ID 的 Cookie
+	SessionCookieName = "fileserver_session"
+	// CSRFHeader 通过会话认证的写请求需要在该请求头中带上会话的 CSRF 令牌
+	CSRFHeader = "X-CSRF-Token"
+)
+
+//go:embed login.html
+var loginHtml string
+
+var loginTemplate = template.Must(template.New("login").Parse(loginHtml))
+
+// Session 网页登录后的会话
+type Session struct {
+	Username  string
+	CSRFToken string
+	ExpiresAt time.Time
+}
+
+// SessionStore 在内存中保存网页登录的会话，服务重启后需要重新登录
+type SessionStore struct {
+	ttl time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+// NewSessionStore 创建会话存储，会话在登录 ttl 后过期
+func NewSessionStore(ttl time.Duration) *SessionStore {
+	return &SessionStore{ttl: ttl, sessions: make(map[string]*Session)}
+}
+
+// Create 为用户创建会话，返回会话 ID 和会话
+func (s *SessionStore) Create(username string) (string, *Session, error) {
+	id, err := randomHex(32)
+	if err != nil {
+		return "", nil, err
+	}
+	csrfToken, err := randomHex(32)
+	if err != nil {
+		return "", nil, err
+	}
+	session := &Session{
+		Username:  username,
+		CSRFToken: csrfToken,
+		ExpiresAt: time.Now().Add(s.ttl),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruneLocked()
+	s.sessions[id] = session
+	return id, session, nil
+}
+
+// Get 返回会话，会话不存在或已过期时返回 nil
+func (s *SessionStore) Get(id string) *Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session := s.sessions[id]
+	if session == nil {
+		return nil
+	}
+	if !time.Now().Before(session.ExpiresAt) {
+		delete(s.sessions, id)
+		return nil
+	}
+	return session
+}
+
+// Delete 删除会话
+func (s *SessionStore) Delete(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+}
+
+// RevokeUser 删除用户的所有会话，返回删除的数量
+func (s *SessionStore) RevokeUser(username string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	revoked := 0
+	for id, session := range s.sessions {
+		if session.Username == username {
+			delete(s.sessions, id)
+			revoked++
+		}
+	}
+	return revoked
+}
+
+// pruneLocked 删除已过期的会话，调用方需持有 s.mu
+func (s *SessionStore) pruneLocked() {
+	now := time.Now()
+	for id, session := range s.sessions {
+		if !now.Before(session.ExpiresAt) {
+			delete(s.sessions, id)
+		}
+	}
+}
+
+// validCSRF 判断请求头中的 CSRF 令牌是否与会话一致
+func (session *Session) validCSRF(r *http.Request) bool {
+	token := r.Header.Get(CSRFHeader)
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(session.CSRFToken)) == 1
+}
+
+// sessionFromRequest 返回请求的会话 Cookie 对应的会话 ID 和会话，没有有效会话时返回 nil
+func (a *AuthConfig) sessionFromRequest(r *http.Request) (string, *Session) {
+	if a.Sessions == nil {
+		return "", nil
+	}
+	cookie, err := r.Cookie(SessionCookieName)
+	if err != nil || cookie.Value == "" {
+		return "", nil
+	}
+	return cookie.Value, a.Sessions.Get(cookie.Value)
+}
+
+// revokeSessions 删除用户的所有会话，用户文件中的用户被删除或修改密码时调用
+func (a *AuthConfig) revokeSessions(usernames []string) {
+	if a.Sessions == nil {
+		return
+	}
+	for _, username := range usernames {
+		if revoked := a.Sessions.RevokeUser(username); revoked > 0 {
+			log.Printf("revoked %d sessions of user %s", revoked, username)
+		}
+	}
+}
+
+// setSessionCookie 设置会话 Cookie，maxAge 小于 0 时删除 Cookie
+func setSessionCookie(w http.ResponseWriter, r *http.Request, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		// 通过 HTTPS 访问时 Cookie 只在 HTTPS 连接中发送
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// SessionResponse 登录和查询会话的响应
+type SessionResponse struct {
+	// 当前用户，未登录时为空
+	Username string `json:"username"`
+	// 通过会话认证的写请求需要在 X-CSRF-Token 请求头中带上该令牌
+	CSRFToken string `json:"csrfToken,omitempty"`
+}
+
+// loginRequest 登录请求的参数
+type loginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// 表单登录成功后跳转的页面
+	Redirect string `json:"redirect"`
+}
+
+// ServeLogin 处理登录请求，接受 JSON 或表单中的 username 和 password，成功后设置会话 Cookie。
+// JSON 请求返回 SessionResponse，表单请求跳转到 redirect 指定的页面
+func (a *AuthConfig) ServeLogin(w http.ResponseWriter, r *http.Request) {
+	if a.Sessions == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "405 method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req loginRequest
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	isJSON := mediaType == "application/json"
+	if isJSON {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request: " + err.Error()})
+			return
+		}
+	} else {
+		req.Username = r.PostFormValue("username")
+		req.Password = r.PostFormValue("password")
+		req.Redirect = r.PostFormValue("redirect")
+	}
+
+	ok := false
+	var err error
+	if req.Username != "" && a.enabled() {
+		ok, err = a.verifyCredentials(r, req.Username, req.Password)
+	}
+	if err != nil {
+		w.Header().Set("Retry-After", strconv.Itoa(a.retryAfter(r, req.Username)))
+		if isJSON {
+			writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": err.Error()})
+		} else {
+			a.serveLoginPage(w, http.StatusTooManyRequests, req.Redirect, "尝试次数过多，请稍后再试")
+		}
+		return
+	}
+	if !ok {
+		if isJSON {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid username or password"})
+		} else {
+			a.serveLoginPage(w, http.StatusUnauthorized, req.Redirect, "用户名或密码错误")
+		}
+		return
+	}
+
+	// 登录时替换已有的会话，避免会话固定攻击
+	if oldID, _ := a.sessionFromRequest(r); oldID != "" {
+		a.Sessions.Delete(oldID)
+	}
+	id, session, err := a.Sessions.Create(req.Username)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	setSessionCookie(w, r, id, int(a.Sessions.ttl.Seconds()))
+
+	if isJSON {
+		writeJSON(w, http.StatusOK, SessionResponse{Username: session.Username, CSRFToken: session.CSRFToken})
+		return
+	}
+	http.Redirect(w, r, safeRedirect(req.Redirect), http.StatusSeeOther)
+}
+
+// ServeLogout 处理退出请求，删除会话和会话 Cookie，通过会话认证时需要 CSRF 令牌
+func (a *AuthConfig) ServeLogout(w http.ResponseWriter, r *http.Request) {
+	if a.Sessions == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "405 method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, session := a.sessionFromRequest(r)
+	if session != nil {
+		if !session.validCSRF(r) {
+			http.Error(w, "403 invalid csrf token", http.StatusForbidden)
+			return
+		}
+		a.Sessions.Delete(id)
+	}
+	setSessionCookie(w, r, "", -1)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ServeSession 返回当前登录的用户和会话的 CSRF 令牌，网页加载后通过它获取登录状态
+func (a *AuthConfig) ServeSession(w http.ResponseWriter, r *http.Request) {
+	if a.Sessions == nil {
+		http.NotFound(w, r)
+		return
+	}
+	var resp SessionResponse
+	if id, _ := a.authenticate(r); id != nil {
+		resp.Username = id.username
+		if id.session != nil {
+			resp.CSRFToken = id.session.CSRFToken
+		}
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// serveLoginPage 返回登录页面，登录成功后跳转到 redirect
+func (a *AuthConfig) serveLoginPage(w http.ResponseWriter, status int, redirect, errMsg string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	loginTemplate.Execute(w, map[string]string{
+		"Realm":    a.Realm,
+		"Redirect": safeRedirect(redirect),
+		"Error":    errMsg,
+	})
+}
+
+// wantsLoginPage 判断被拒绝的请求是否来自浏览器的页面访问，这时返回登录页面而不是基础认证
+func wantsLoginPage(r *http.Request) bool {
+	return r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// isXHR 判断是否为网页脚本发出的请求，这时 401 不应触发浏览器的基础认证弹窗
+func isXHR(r *http.Request) bool {
+	return r.Header.Get("X-Requested-With") == "XMLHttpRequest"
+}
+
+// safeRedirect 只允许跳转到本站的路径，避免被用作开放跳转
+func safeRedirect(redirect string) string {
+	if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") || strings.HasPrefix(redirect, "/\\") {
+		return "/"
+	}
+	return redirect
+}
+
+// writeJSON 以 JSON 格式返回 v
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSession 测试网页登录的会话和 CSRF 保护
+func TestSession(t *testing.T) {
+	a := NewAuthConfig("bob", "secret")
+	a.SetSessionStore(NewSessionStore(time.Hour))
+	a.SetReadPermission(true)
+	handler := a.HTTPMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(UserFromRequest(r)))
+	}))
+
+	// 错误的密码
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/:login", strings.NewReader(`{"username":"bob","password":"wrong"}`))
+	req.Header.Set("Content-Type", "application/json")
+	a.ServeLogin(rec, req)
+	if rec.Code != http.StatusUnauthorized || len(rec.Result().Cookies()) != 0 {
+		t.Fatalf("login with wrong password: status = %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/:login", strings.NewReader(`{"username":"bob","password":"secret"}`))
+	req.Header.Set("Content-Type", "application/json")
+	a.ServeLogin(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	var resp SessionResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if resp.Username != "bob" || resp.CSRFToken == "" {
+		t.Fatalf("login response = %+v", resp)
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != SessionCookieName || !cookies[0].HttpOnly {
+		t.Fatalf("login cookies = %v", cookies)
+	}
+	cookie := cookies[0]
+
+	do := func(method, path, csrf string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.AddCookie(cookie)
+		if csrf != "" {
+			req.Header.Set(CSRFHeader, csrf)
+		}
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do("GET", "/file", "", nil); rec.Code != http.StatusOK || rec.Body.String() != "bob" {
+		t.Errorf("GET with session: status = %d, user = %q", rec.Code, rec.Body.String())
+	}
+	if rec := do("POST", "/", "", nil); rec.Code != http.StatusForbidden {
+		t.Errorf("POST without csrf token: status = %d, want 403", rec.Code)
+	}
+	if rec := do("POST", "/", "wrong", nil); rec.Code != http.StatusForbidden {
+		t.Errorf("POST with wrong csrf token: status = %d, want 403", rec.Code)
+	}
+	if rec := do("POST", "/", resp.CSRFToken, nil); rec.Code != http.StatusOK {
+		t.Errorf("POST with csrf token: status = %d, want 200", rec.Code)
+	}
+
+	// 会话 Cookie 查询登录状态
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/:session", nil)
+	req.AddCookie(cookie)
+	a.ServeSession(rec, req)
+	var current SessionResponse
+	json.Unmarshal(rec.Body.Bytes(), &current)
+	if current != resp {
+		t.Errorf("session = %+v, want %+v", current, resp)
+	}
+
+	// 退出需要 CSRF 令牌
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/:logout", nil)
+	req.AddCookie(cookie)
+	a.ServeLogout(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("logout without csrf token: status = %d, want 403", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	req.Header.Set(CSRFHeader, resp.CSRFToken)
+	a.ServeLogout(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("logout: status = %d, want 204", rec.Code)
+	}
+
+	// 退出后未登录：脚本请求不要求基础认证，页面访问返回登录页面，其它客户端要求基础认证
+	rec = do("GET", "/file", "", map[string]string{"X-Requested-With": "XMLHttpRequest"})
+	if rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") != "" {
+		t.Errorf("XHR after logout: status = %d, WWW-Authenticate = %q", rec.Code, rec.Header().Get("WWW-Authenticate"))
+	}
+	rec = do("GET", "/file?x=1", "", map[string]string{"Accept": "text/html,*/*"})
+	if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), `value="/file?x=1"`) {
+		t.Errorf("page after logout: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	rec = do("GET", "/file", "", nil)
+	if rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("GET after logout: status = %d, WWW-Authenticate = %q", rec.Code, rec.Header().Get("WWW-Authenticate"))
+	}
+
+	// 表单登录后跳转，不允许跳转到其它网站
+	for redirect, want := range map[string]string{"/dir/": "/dir/", "//evil.com": "/", "http://evil.com": "/"} {
+		form := url.Values{"username": {"bob"}, "password": {"secret"}, "redirect": {redirect}}
+		req := httptest.NewRequest("POST", "/:login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		a.ServeLogin(rec, req)
+		if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != want {
+			t.Errorf("form login redirect %q: status = %d, location = %q, want %q", redirect, rec.Code, rec.Header().Get("Location"), want)
+		}
+	}
+
+	// 会话过期
+	expiring := NewSessionStore(time.Millisecond)
+	id, _, err := expiring.Create("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if expiring.Get(id) != nil {
+		t.Error("expired session should not be returned")
+	}
+}
+
+// TestRevokeSessions 测试用户被删除或修改密码后会话失效
+func TestRevokeSessions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users")
+	for _, name := range []string{"alice", "bob", "carol"} {
+		if err := AddUser(path, name, "secret"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	users, err := LoadUserStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := NewAuthConfig("", "")
+	a.SetUserStore(users)
+	sessions := NewSessionStore(time.Hour)
+	a.SetSessionStore(sessions)
+
+	ids := make(map[string]string)
+	for _, name := range []string{"alice", "bob", "carol"} {
+		id, _, err := sessions.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[name] = id
+	}
+
+	if err := SetPassword(path, "alice", "changed"); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteUser(path, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetHome(path, "carol", "carol"); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]bool{"alice": false, "bob": false, "carol": true} {
+		if got := sessions.Get(ids[name]) != nil; got != want {
+			t.Errorf("session of %s valid = %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 令牌的权限范围
+const (
+	// ScopeRead 浏览和下载文件
+	ScopeRead = "read"
+	// ScopeWrite 上传、创建目录、删除等写操作
+	ScopeWrite = "write"
+	// ScopeDownloadTasks 创建、查看和管理离线下载任务
+	ScopeDownloadTasks = "download-tasks"
+)
+
+// ErrTokenNotFound 令牌不存在
+var ErrTokenNotFound = errors.New("token not found")
+
+// Token API 令牌，文件中只保存令牌密钥的哈希
+type Token struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+	// 令牌代表的用户，访问控制和主目录按该用户处理
+	User      string     `json:"user"`
+	Hash      string     `json:"hash"`
+	Scopes    []string   `json:"scopes"`
+	CreatedAt time.Time  `json:"createdAt"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// HasScope 判断令牌是否有 scope 权限
+func (t *Token) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// expired 判断令牌是否已过期
+func (t *Token) expired() bool {
+	return t.ExpiresAt != nil && !time.Now().Before(*t.ExpiresAt)
+}
+
+// TokenStore 从 JSON 格式的令牌文件加载 API 令牌
+type TokenStore struct {
+	path string
+
+	mu      sync.RWMutex
+	tokens  map[string]*Token
+	version fileVersion
+}
+
+// LoadTokenStore 加载令牌文件
+func LoadTokenStore(path string) (*TokenStore, error) {
+	s := &TokenStore{path: path}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload 重新读取令牌文件
+func (s *TokenStore) Reload() error {
+	version, err := statVersion(s.path)
+	if err != nil {
+		return err
+	}
+	tokens, err := readTokensFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens = make(map[string]*Token, len(tokens))
+	for _, token := range tokens {
+		s.tokens[token.ID] = token
+	}
+	s.version = version
+	return nil
+}
+
+// Watch 每隔 interval 检查令牌文件，文件被修改后自动重新加载，返回停止函数
+func (s *TokenStore) Watch(interval time.Duration) (stop func()) {
+	return watchFile(s.path, interval, s.changed, s.Reload)
+}
+
+// changed 判断令牌文件在上次加载后是否被修改
+func (s *TokenStore) changed() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return changedSince(s.path, s.version)
+}
+
+// Authenticate 验证令牌，返回令牌信息，令牌无效或已过期时返回 nil
+func (s *TokenStore) Authenticate(raw string) *Token {
+	id, secret, ok := strings.Cut(raw, ".")
+	if !ok {
+		return nil
+	}
+
+	s.mu.RLock()
+	token := s.tokens[id]
+	s.mu.RUnlock()
+
+	if token == nil || token.expired() {
+		return nil
+	}
+	if subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(token.Hash)) != 1 {
+		return nil
+	}
+	return token
+}
+
+// Tokens 返回所有令牌，按创建时间排序
+func (s *TokenStore) Tokens() []*Token {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tokens := make([]*Token, 0, len(s.tokens))
+	for _, token := range s.tokens {
+		tokens = append(tokens, token)
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt.Before(tokens[j].CreatedAt)
+	})
+	return tokens
+}
+
+// hashSecret 计算令牌密钥的哈希，密钥是随机生成的，不需要加盐
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomHex 生成 n 字节的随机数的十六进制表示
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// readTokensFile 读取令牌文件，文件不存在时返回空列表
+func readTokensFile(path string) ([]*Token, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var tokens []*Token
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("invalid tokens file %s: %w", path, err)
+	}
+	return tokens, nil
+}
+
+// writeTokensFile 写入令牌文件，先写临时文件再重命名
+func writeTokensFile(path string, tokens []*Token) error {
+	data, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// CreateToken 在令牌文件中创建令牌，ttl 为 0 时永不过期，返回令牌信息和只在此时可见的令牌字符串
+func CreateToken(path, user, name string, scopes []string, ttl time.Duration) (*Token, string, error) {
+	if err := validateUsername(user); err != nil {
+		return nil, "", err
+	}
+	if len(scopes) == 0 {
+		return nil, "", fmt.Errorf("at least one scope is required")
+	}
+	for _, scope := range scopes {
+		switch scope {
+		case ScopeRead, ScopeWrite, ScopeDownloadTasks:
+		default:
+			return nil, "", fmt.Errorf("invalid scope: %q", scope)
+		}
+	}
+	if ttl < 0 {
+		return nil, "", fmt.Errorf("expiry must not be negative")
+	}
+
+	tokens, err := readTokensFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	id, err := randomHex(8)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return nil, "", err
+	}
+
+	token := &Token{
+		ID:        id,
+		Name:      name,
+		User:      user,
+		Hash:      hashSecret(secret),
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+	}
+	if ttl > 0 {
+		expiresAt := token.CreatedAt.Add(ttl)
+		token.ExpiresAt = &expiresAt
+	}
+	if err := writeTokensFile(path, append(tokens, token)); err != nil {
+		return nil, "", err
+	}
+	return token, id + "." + secret, nil
+}
+
+// RevokeToken 从令牌文件中删除令牌
+func RevokeToken(path, id string) error {
+	tokens, err := readTokensFile(path)
+	if err != nil {
+		return err
+	}
+	for i, token := range tokens {
+		if token.ID == id {
+			tokens = append(tokens[:i], tokens[i+1:]...)
+			return writeTokensFile(path, tokens)
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrTokenNotFound, id)
+}
@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestTokenStore 测试 API 令牌的创建、验证、权限范围和吊销
+func TestTokenStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	reader, readRaw, err := CreateToken(path, "alice", "ci", []string{ScopeRead}, 0)
+	if err != nil {
+		t.Fatalf("CreateToken failed: %v", err)
+	}
+	_, tasksRaw, _ := CreateToken(path, "bob", "", []string{ScopeDownloadTasks}, 0)
+	_, expiredRaw, _ := CreateToken(path, "alice", "", []string{ScopeWrite}, time.Nanosecond)
+	if _, _, err := CreateToken(path, "alice", "", []string{"admin"}, 0); err == nil {
+		t.Error("creating token with unknown scope should fail")
+	}
+
+	tokens, err := LoadTokenStore(path)
+	if err != nil {
+		t.Fatalf("LoadTokenStore failed: %v", err)
+	}
+	if token := tokens.Authenticate(readRaw); token == nil || token.User != "alice" {
+		t.Errorf("Authenticate(read token) = %+v", token)
+	}
+	if tokens.Authenticate(expiredRaw) != nil {
+		t.Error("expired token should be rejected")
+	}
+	if tokens.Authenticate(reader.ID+".wrong") != nil {
+		t.Error("token with wrong secret should be rejected")
+	}
+
+	a := NewAuthConfig("", "")
+	a.SetTokenStore(tokens)
+	a.SetPathScope("/:tasks", ScopeDownloadTasks)
+	handler := a.HTTPMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(UserFromRequest(r)))
+	}))
+	tests := []struct {
+		method, path, token string
+		want                int
+	}{
+		{"GET", "/file", readRaw, http.StatusOK},
+		{"PUT", "/file", readRaw, http.StatusForbidden},
+		{"GET", "/:tasks", readRaw, http.StatusForbidden},
+		{"GET", "/:tasks", tasksRaw, http.StatusOK},
+		{"GET", "/file", tasksRaw, http.StatusForbidden},
+		{"POST", "/dir", tasksRaw, http.StatusOK},
+		{"POST", "/dir", expiredRaw, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		req.Header.Set("Authorization", "Bearer "+tt.token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s %s: status = %d, want %d", tt.method, tt.path, rec.Code, tt.want)
+		}
+	}
+
+	// POST 还用于下载任务 API，由处理函数通过 HasScope 检查具体的权限
+	req := httptest.NewRequest("POST", "/dir", nil)
+	req.Header.Set("Authorization", "Bearer "+tasksRaw)
+	req, status := a.Check(req, "/dir")
+	if status != 0 || UserFromRequest(req) != "bob" || HasScope(req, ScopeWrite) || !HasScope(req, ScopeDownloadTasks) {
+		t.Errorf("POST with download-tasks token: status = %d, user = %q", status, UserFromRequest(req))
+	}
+
+	stop := tokens.Watch(10 * time.Millisecond)
+	defer stop()
+	if err := RevokeToken(path, reader.ID); err != nil {
+		t.Fatalf("RevokeToken failed: %v", err)
+	}
+	if err := RevokeToken(path, reader.ID); !errors.Is(err, ErrTokenNotFound) {
+		t.Errorf("revoking missing token: err = %v, want ErrTokenNotFound", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for tokens.Authenticate(readRaw) != nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if tokens.Authenticate(readRaw) != nil {
+		t.Error("revoked token should be rejected after reload")
+	}
+}
+
+// TestTokenUser 测试配置了用户文件时，不存在或已删除的用户的令牌无效
+func TestTokenUser(t *testing.T) {
+	dir := t.TempDir()
+	usersFile := filepath.Join(dir, "users")
+	tokensFile := filepath.Join(dir, "tokens.json")
+	if err := AddUser(usersFile, "bob", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	_, bobRaw, _ := CreateToken(tokensFile, "bob", "", []string{ScopeWrite}, 0)
+	_, ghostRaw, _ := CreateToken(tokensFile, "ghost", "", []string{ScopeWrite}, 0)
+	_, adminRaw, _ := CreateToken(tokensFile, "admin", "", []string{ScopeWrite}, 0)
+	users, err := LoadUserStore(usersFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := LoadTokenStore(tokensFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := NewAuthConfig("admin", "secret")
+	a.SetUserStore(users)
+	a.SetTokenStore(tokens)
+	handler := a.HTTPMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	status := func(raw string) int {
+		req := httptest.NewRequest("POST", "/dir", nil)
+		req.Header.Set("Authorization", "Bearer "+raw)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := status(bobRaw); code != http.StatusOK {
+		t.Errorf("token of bob: status = %d, want 200", code)
+	}
+	if code := status(adminRaw); code != http.StatusOK {
+		t.Errorf("token of the --username account: status = %d, want 200", code)
+	}
+	if code := status(ghostRaw); code != http.StatusUnauthorized {
+		t.Errorf("token of unknown user: status = %d, want 401", code)
+	}
+	if err := DeleteUser(usersFile, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if code := status(bobRaw); code != http.StatusUnauthorized {
+		t.Errorf("token of deleted user: status = %d, want 401", code)
+	}
+}
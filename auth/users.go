@@ -0,0 +1,338 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrUserExists 用户已存在
+	ErrUserExists = errors.New("user already exists")
+	// ErrUserNotFound 用户不存在
+	ErrUserNotFound = errors.New("user not found")
+)
+
+// User 用户文件中的一个账号
+type User struct {
+	// 用户名
+	Name string
+	// 密码哈希，支持 bcrypt（$2a$、$2b$、$2y$）和 argon2id（$argon2id$）
+	Hash string
+	// 主目录，为空时使用默认的主目录
+	Home string
+}
+
+// UserStore 从 htpasswd 格式的用户文件加载账号，每行为 "用户名:密码哈希[:主目录]"，# 开头的行为注释
+type UserStore struct {
+	path string
+
+	mu       sync.RWMutex
+	users    map[string]*User
+	version  fileVersion
+	onChange func(usernames []string)
+}
+
+// LoadUserStore 加载用户文件
+func LoadUserStore(path string) (*UserStore, error) {
+	s := &UserStore{path: path}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload 重新读取用户文件
+func (s *UserStore) Reload() error {
+	version, err := statVersion(s.path)
+	if err != nil {
+		return err
+	}
+	users, err := readUsersFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	loaded := make(map[string]*User, len(users))
+	for _, user := range users {
+		loaded[user.Name] = user
+	}
+
+	s.mu.Lock()
+	// 被删除或修改了密码的用户
+	var changed []string
+	for name, user := range s.users {
+		if current := loaded[name]; current == nil || current.Hash != user.Hash {
+			changed = append(changed, name)
+		}
+	}
+	s.users = loaded
+	s.version = version
+	onChange := s.onChange
+	s.mu.Unlock()
+
+	if len(changed) > 0 && onChange != nil {
+		sort.Strings(changed)
+		onChange(changed)
+	}
+	return nil
+}
+
+// OnChange 设置重新加载后用户被删除或密码被修改时的回调
+func (s *UserStore) OnChange(f func(usernames []string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onChange = f
+}
+
+// Watch 每隔 interval 检查用户文件，文件被修改后自动重新加载，返回停止函数
+func (s *UserStore) Watch(interval time.Duration) (stop func()) {
+	return watchFile(s.path, interval, s.changed, s.Reload)
+}
+
+// changed 判断用户文件在上次加载后是否被修改
+func (s *UserStore) changed() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return changedSince(s.path, s.version)
+}
+
+// Authenticate 验证用户名和密码
+func (s *UserStore) Authenticate(username, password string) bool {
+	s.mu.RLock()
+	user := s.users[username]
+	s.mu.RUnlock()
+
+	if user == nil {
+		// 用户不存在时同样计算一次哈希，避免通过响应时间猜测用户名
+		dummyOnce.Do(func() {
+			dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
+		})
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return false
+	}
+	return VerifyPassword(user.Hash, password)
+}
+
+// Exists 判断用户是否在用户文件中
+func (s *UserStore) Exists(username string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.users[username] != nil
+}
+
+// Home 返回用户在用户文件中配置的主目录，没有配置时为空
+func (s *UserStore) Home(username string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if user := s.users[username]; user != nil {
+		return user.Home
+	}
+	return ""
+}
+
+// Users 返回所有用户名
+func (s *UserStore) Users() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names := make([]string, 0, len(s.users))
+	for name := range s.users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// dummyHash 用于用户不存在时的哈希计算，第一次用到时生成
+var (
+	dummyHash []byte
+	dummyOnce sync.Once
+)
+
+// HashPassword 使用 bcrypt 生成密码哈希
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// VerifyPassword 校验密码是否与 bcrypt 或 argon2id 哈希匹配
+func VerifyPassword(hash, password string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case strings.HasPrefix(hash, "$argon2id$"):
+		return verifyArgon2id(hash, password)
+	default:
+		return false
+	}
+}
+
+// verifyArgon2id 校验 PHC 格式的 argon2id 哈希：$argon2id$v=19$m=65536,t=3,p=4$salt$hash
+func verifyArgon2id(hash, password string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return false
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false
+	}
+	var memory, iterations uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &threads); err != nil {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false
+	}
+
+	actual := argon2.IDKey([]byte(password), salt, iterations, memory, threads, uint32(len(expected)))
+	return subtle.ConstantTimeCompare(actual, expected) == 1
+}
+
+// readUsersFile 读取用户文件，保持文件中的顺序
+func readUsersFile(path string) ([]*User, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var users []*User
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, hash, ok := strings.Cut(line, ":")
+		if !ok || name == "" || hash == "" {
+			return nil, fmt.Errorf("%s:%d: invalid user entry", path, lineNo)
+		}
+		// 密码哈希中不包含冒号，之后的部分为主目录
+		hash, home, _ := strings.Cut(hash, ":")
+		users = append(users, &User{Name: name, Hash: hash, Home: home})
+	}
+	return users, scanner.Err()
+}
+
+// writeUsersFile 写入用户文件，先写临时文件再重命名
+func writeUsersFile(path string, users []*User) error {
+	var buf bytes.Buffer
+	for _, user := range users {
+		if user.Home != "" {
+			fmt.Fprintf(&buf, "%s:%s:%s\n", user.Name, user.Hash, user.Home)
+		} else {
+			fmt.Fprintf(&buf, "%s:%s\n", user.Name, user.Hash)
+		}
+	}
+
+	tmpPath := path + ".tmp"
+	// 文件中保存有密码哈希，只允许当前用户读写
+	if err := os.WriteFile(tmpPath, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// validateUsername 检查用户名是否可以写入用户文件
+func validateUsername(username string) error {
+	if username == "" || strings.ContainsAny(username, ":#\r\n\t ") {
+		return fmt.Errorf("invalid username: %q", username)
+	}
+	return nil
+}
+
+// AddUser 向用户文件添加用户，文件不存在时自动创建
+func AddUser(path, username, password string) error {
+	if err := validateUsername(username); err != nil {
+		return err
+	}
+	users, err := readUsersFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, user := range users {
+		if user.Name == username {
+			return fmt.Errorf("%w: %s", ErrUserExists, username)
+		}
+	}
+
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	users = append(users, &User{Name: username, Hash: hash})
+	return writeUsersFile(path, users)
+}
+
+// SetPassword 修改用户文件中用户的密码
+func SetPassword(path, username, password string) error {
+	users, err := readUsersFile(path)
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		if user.Name == username {
+			if user.Hash, err = HashPassword(password); err != nil {
+				return err
+			}
+			return writeUsersFile(path, users)
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrUserNotFound, username)
+}
+
+// SetHome 修改用户文件中用户的主目录，home 为空时使用默认的主目录
+func SetHome(path, username, home string) error {
+	if strings.ContainsAny(home, "\r\n") {
+		return fmt.Errorf("invalid home directory: %q", home)
+	}
+	users, err := readUsersFile(path)
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		if user.Name == username {
+			user.Home = home
+			return writeUsersFile(path, users)
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrUserNotFound, username)
+}
+
+// DeleteUser 从用户文件删除用户
+func DeleteUser(path, username string) error {
+	users, err := readUsersFile(path)
+	if err != nil {
+		return err
+	}
+	for i, user := range users {
+		if user.Name == username {
+			users = append(users[:i], users[i+1:]...)
+			return writeUsersFile(path, users)
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrUserNotFound, username)
+}
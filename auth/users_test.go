@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// TestUserStore 测试用户文件的管理、验证和重新加载
+func TestUserStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users")
+	if err := AddUser(path, "alice", "secret"); err != nil {
+		t.Fatalf("AddUser failed: %v", err)
+	}
+	if err := AddUser(path, "alice", "other"); !errors.Is(err, ErrUserExists) {
+		t.Errorf("adding existing user: err = %v, want ErrUserExists", err)
+	}
+	if err := AddUser(path, "bad:name", "x"); err == nil {
+		t.Error("adding user with colon should fail")
+	}
+
+	// 其它工具生成的 argon2id 哈希
+	salt := []byte("0123456789abcdef")
+	key := argon2.IDKey([]byte("pa55"), salt, 1, 1024, 1, 32)
+	entry := fmt.Sprintf("# comment\nbob:$argon2id$v=%d$m=1024,t=1,p=1$%s$%s\n", argon2.Version,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(entry)
+	f.Close()
+
+	users, err := LoadUserStore(path)
+	if err != nil {
+		t.Fatalf("LoadUserStore failed: %v", err)
+	}
+	tests := []struct {
+		username, password string
+		want               bool
+	}{
+		{"alice", "secret", true},
+		{"alice", "wrong", false},
+		{"bob", "pa55", true},
+		{"bob", "secret", false},
+		{"nobody", "secret", false},
+	}
+	for _, tt := range tests {
+		if got := users.Authenticate(tt.username, tt.password); got != tt.want {
+			t.Errorf("Authenticate(%s, %s) = %v, want %v", tt.username, tt.password, got, tt.want)
+		}
+	}
+
+	stop := users.Watch(10 * time.Millisecond)
+	defer stop()
+	if err := SetHome(path, "alice", "shared/alice"); err != nil {
+		t.Fatalf("SetHome failed: %v", err)
+	}
+	if err := SetPassword(path, "alice", "changed"); err != nil {
+		t.Fatalf("SetPassword failed: %v", err)
+	}
+	if err := DeleteUser(path, "bob"); err != nil {
+		t.Fatalf("DeleteUser failed: %v", err)
+	}
+	if err := DeleteUser(path, "bob"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("deleting missing user: err = %v, want ErrUserNotFound", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for users.Authenticate("bob", "pa55") && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if users.Authenticate("bob", "pa55") || !users.Authenticate("alice", "changed") {
+		t.Errorf("users file was not reloaded, users = %v", users.Users())
+	}
+
+	config := NewAuthConfig("", "")
+	config.SetUserStore(users)
+	if !config.IsAuthRequired("POST", "/") {
+		t.Error("write should require auth with a users file")
+	}
+	if !config.ValidateCredentials("alice", "changed") || config.ValidateCredentials("alice", "secret") {
+		t.Error("ValidateCredentials should check the users file")
+	}
+	if home := config.HomeDir("alice"); home != "shared/alice" {
+		t.Errorf("HomeDir(alice) = %q, want shared/alice", home)
+	}
+}
@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// fileVersion 文件的修改时间和大小，用于判断文件在加载后是否被修改
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+}
+
+// statVersion 返回文件当前的版本
+func statVersion(path string) (fileVersion, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return fileVersion{}, err
+	}
+	return fileVersion{modTime: stat.ModTime(), size: stat.Size()}, nil
+}
+
+// changedSince 判断文件是否与 v 不同，无法读取时视为未修改
+func changedSince(path string, v fileVersion) bool {
+	current, err := statVersion(path)
+	if err != nil {
+		return false
+	}
+	return !current.modTime.Equal(v.modTime) || current.size != v.size
+}
+
+// watchFile 每隔 interval 调用 changed 检查文件，文件被修改后调用 reload，返回停止函数
+func watchFile(path string, interval time.Duration, changed func() bool, reload func() error) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				if changed() {
+					if err := reload(); err != nil {
+						log.Printf("failed to reload %s: %v", path, err)
+					} else {
+						log.Printf("reloaded %s", path)
+					}
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			ticker.Stop()
+			close(done)
+		})
+	}
+}
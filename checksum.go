@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// checksumAlgorithms 支持的校验算法
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+}
+
+// normalizeChecksums 检查算法和摘要格式，返回小写的摘要，空值会被忽略
+func normalizeChecksums(checksums map[string]string) (map[string]string, error) {
+	normalized := make(map[string]string)
+	for algorithm, digest := range checksums {
+		digest = strings.ToLower(strings.TrimSpace(digest))
+		if digest == "" {
+			continue
+		}
+
+		newHash, ok := checksumAlgorithms[algorithm]
+		if !ok {
+			return nil, fmt.Errorf("%w: unsupported checksum algorithm: %s", ErrBadRequest, algorithm)
+		}
+		if decoded, err := hex.DecodeString(digest); err != nil || len(decoded) != newHash().Size() {
+			return nil, fmt.Errorf("%w: invalid %s checksum: %s", ErrBadRequest, algorithm, digest)
+		}
+		normalized[algorithm] = digest
+	}
+	if len(normalized) == 0 {
+		return nil, nil
+	}
+	return normalized, nil
+}
+
+// verifyChecksums 一次读取文件计算 expected 中的所有摘要，返回计算结果，不一致时返回错误
+func verifyChecksums(path string, expected map[string]string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	hashes := make(map[string]hash.Hash, len(expected))
+	writers := make([]io.Writer, 0, len(expected))
+	for algorithm := range expected {
+		h := checksumAlgorithms[algorithm]()
+		hashes[algorithm] = h
+		writers = append(writers, h)
+	}
+	if _, err := io.Copy(io.MultiWriter(writers...), file); err != nil {
+		return nil, err
+	}
+
+	actual := make(map[string]string, len(hashes))
+	for algorithm, h := range hashes {
+		actual[algorithm] = hex.EncodeToString(h.Sum(nil))
+	}
+
+	algorithms := make([]string, 0, len(expected))
+	for algorithm := range expected {
+		algorithms = append(algorithms, algorithm)
+	}
+	sort.Strings(algorithms)
+	for _, algorithm := range algorithms {
+		if actual[algorithm] != expected[algorithm] {
+			return actual, fmt.Errorf("%s %w: expected %s, got %s", algorithm, ErrChecksumMismatch, expected[algorithm], actual[algorithm])
+		}
+	}
+	return actual, nil
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/melbahja/got"
+)
+
+const (
+	// 并发下载的分块数
+	chunkConcurrency = 8
+	// 分块大小下限
+	minChunkSize = 2 << 20
+)
+
+// downloadJob 是一个任务当前这次运行的状态，暂停后保留未完成的分块用于断点续传
+type downloadJob struct {
+	download *got.Download
+	cancel   context.CancelFunc
+
+	// dir 是目标目录，path 是写入中的文件，位于 dir 中任务的临时目录里，完成后移动到 target
+	dir       string
+	path      string
+	target    string
+	startedAt time.Time
+	// ready 表示 total、rangeable 和 pending 已确定，无需再调用 Init
+	ready     bool
+	total     uint64
+	rangeable bool
+	// base 是本次运行之前已完成的字节数
+	base uint64
+	// status 是下载源最后返回的错误状态码，原子读写
+	status int32
+
+	mu      sync.Mutex
+	pending []*got.Chunk
+}
+
+// newDownloadJob 为任务创建一次新的下载运行，name 为空时由 got 根据 URL 或响应头决定文件名。
+// 文件先写入 dir 中任务的临时目录，下载完成后才出现在 dir 中
+func newDownloadJob(task *DownloadTaskInfo, dir, name string) *downloadJob {
+	ctx, cancel := context.WithCancel(context.Background())
+	download := got.NewDownload(ctx, task.Url, name)
+	download.Dir = taskTempDir(dir, task.TaskId)
+	task.Remote.apply(download)
+	job := &downloadJob{
+		download: download,
+		cancel:   cancel,
+		dir:      dir,
+	}
+	download.Client = recordStatus(download.Client, &job.status)
+	return job
+}
+
+// resumeJob 基于上一次运行剩下的分块创建新的运行，无法续传时返回 nil
+func resumeJob(task *DownloadTaskInfo, prev *downloadJob) *downloadJob {
+	if prev == nil || !prev.rangeable {
+		return nil
+	}
+	prev.mu.Lock()
+	pending := make([]*got.Chunk, 0, len(prev.pending))
+	for _, c := range prev.pending {
+		pending = append(pending, &got.Chunk{Start: c.Start, End: c.End})
+	}
+	prev.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	// 分块写入依赖预分配好的文件，文件被改动过就只能重新下载
+	stat, err := os.Stat(prev.path)
+	if err != nil || uint64(stat.Size()) != prev.total {
+		return nil
+	}
+
+	job := newDownloadJob(task, prev.dir, filepath.Base(prev.path))
+	job.path = prev.path
+	job.target = prev.target
+	job.ready = true
+	job.total = prev.total
+	job.rangeable = true
+	job.pending = pending
+	job.base = prev.total
+	for _, c := range pending {
+		job.base -= c.End - c.Start + 1
+	}
+	return job
+}
+
+// prepare 在 got 完成 Init 之后记录文件信息并切分分块
+func (j *downloadJob) prepare() {
+	j.path = j.download.Path()
+	j.target = filepath.Join(j.dir, filepath.Base(j.path))
+	j.ready = true
+	j.total = j.download.TotalSize()
+	j.rangeable = j.download.IsRangeable()
+	if j.rangeable {
+		j.pending = splitChunks(j.total, chunkConcurrency)
+	}
+}
+
+// commit 将下载完成的文件从临时目录移动到目标位置并删除临时目录
+func (j *downloadJob) commit() error {
+	if err := os.Rename(j.path, j.target); err != nil {
+		return err
+	}
+	return os.RemoveAll(j.download.Dir)
+}
+
+// downloaded 返回已下载的字节数
+func (j *downloadJob) downloaded() uint64 {
+	downloaded := j.base + j.download.Size()
+	// Init 探测时下载的首字节会在第一个分块中再次计入
+	if j.total > 0 && downloaded > j.total {
+		downloaded = j.total
+	}
+	return downloaded
+}
+
+// speed 返回本次运行的平均速度（字节/秒）
+func (j *downloadJob) speed() uint64 {
+	elapsed := time.Since(j.startedAt).Milliseconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return j.download.Size() * 1000 / uint64(elapsed)
+}
+
+// fetch 并发下载剩余的分块，每完成一块就从 pending 中移除
+func (j *downloadJob) fetch() error {
+	ctx := j.download.Context()
+	if !j.rangeable {
+		// 不支持 Range 的文件已在 Init 时整体下载
+		return ctx.Err()
+	}
+
+	file, err := os.OpenFile(j.path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := file.Truncate(int64(j.total)); err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	chunks := append([]*got.Chunk(nil), j.pending...)
+	j.mu.Unlock()
+
+	var (
+		wg   sync.WaitGroup
+		once sync.Once
+		ferr error
+		max  = make(chan struct{}, chunkConcurrency)
+	)
+	for _, chunk := range chunks {
+		max <- struct{}{}
+		if ctx.Err() != nil {
+			<-max
+			break
+		}
+
+		j.mu.Lock()
+		rng := &got.Chunk{Start: chunk.Start, End: chunk.End}
+		j.mu.Unlock()
+
+		wg.Add(1)
+		go func(c *got.Chunk) {
+			defer func() {
+				<-max
+				wg.Done()
+			}()
+
+			w := &chunkWriter{job: j, file: file, chunk: c}
+			if err := j.download.DownloadChunk(rng, w); err != nil {
+				once.Do(func() { ferr = err })
+				return
+			}
+			j.done(c)
+		}(chunk)
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return ferr
+}
+
+// queuedJob 是等待调度的任务，job 为 nil 时在开始时再决定续传还是重新下载
+type queuedJob struct {
+	taskId string
+	job    *downloadJob
+}
+
+// chunkWriter 将数据写到分块当前的起点，并随写入推进起点，暂停后从推进后的位置续传
+type chunkWriter struct {
+	job   *downloadJob
+	file  *os.File
+	chunk *got.Chunk
+}
+
+func (w *chunkWriter) Write(b []byte) (int, error) {
+	w.job.mu.Lock()
+	offset := w.chunk.Start
+	w.job.mu.Unlock()
+
+	n, err := w.file.WriteAt(b, int64(offset))
+
+	w.job.mu.Lock()
+	w.chunk.Start += uint64(n)
+	w.job.mu.Unlock()
+	return n, err
+}
+
+// done 将分块标记为已完成
+func (j *downloadJob) done(c *got.Chunk) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for i, p := range j.pending {
+		if p == c {
+			j.pending = append(j.pending[:i], j.pending[i+1:]...)
+			return
+		}
+	}
+}
+
+// splitChunks 将 [0, size) 切分为若干个分块
+func splitChunks(size uint64, concurrency uint64) []*got.Chunk {
+	chunkSize := size / concurrency
+	if chunkSize < minChunkSize {
+		chunkSize = minChunkSize
+	}
+
+	chunks := make([]*got.Chunk, 0, concurrency)
+	for start := uint64(0); start < size; start += chunkSize {
+		end := start + chunkSize
+		if end > size {
+			end = size
+		}
+		chunks = append(chunks, &got.Chunk{Start: start, End: end - 1})
+	}
+	return chunks
+}
@@ -9,6 +9,8 @@ require (
 	github.com/google/uuid v1.3.0
 	github.com/melbahja/got v0.7.0
 	github.com/urfave/cli/v2 v2.25.0
+	golang.org/x/crypto v0.41.0
+	golang.org/x/crypto v0.41.0
 	golang.org/x/net v0.43.0
 )
 
@@ -33,7 +35,6 @@ require (
 	github.com/ugorji/go/codec v1.2.9 // indirect
 	github.com/xrash/smetrics v0.0.0-20201216005158-039620a65673 // indirect
 	golang.org/x/arch v0.0.0-20210923205945-b76863e36670 // indirect
-	golang.org/x/crypto v0.41.0 // indirect
 	golang.org/x/sys v0.35.0 // indirect
 	golang.org/x/text v0.28.0 // indirect
 	google.golang.org/protobuf v1.28.1 // indirect
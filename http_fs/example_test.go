@@ -114,7 +114,7 @@ func ExampleHttpFs_advancedFileOperations() {
 	}
 }
 
-func ExampleWebDAVClient() {
+func Example_webDAVClient() {
 	// WebDAV 客户端示例 - 现在使用独立的 webdav/client 包
 	// import "github.com/breezechen/go_file_server/webdav/client"
 	// 
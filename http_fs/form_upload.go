@@ -0,0 +1,143 @@
+package http_fs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// UploadResult 表单上传中一个文件的结果
+type UploadResult struct {
+	// 上传时的文件名
+	Name string `json:"name"`
+	// 服务端的保存路径，使用 rename 策略时可能与文件名不同
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256,omitempty"`
+	// 目标文件已存在，按 skip 策略没有保存
+	Skipped bool   `json:"skipped,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// FileError 表单上传中一个文件保存失败
+type FileError struct {
+	Name string
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Name, e.Err)
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// MultiUploadError 表单上传中有文件保存失败，Results 包含所有文件（包括成功的）的结果，
+// 可以通过 errors.As 取得每个失败文件的 *FileError
+type MultiUploadError struct {
+	// 服务端的状态码，部分失败时为 207
+	StatusCode int
+	Results    []UploadResult
+	Errors     []*FileError
+}
+
+func (e *MultiUploadError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("upload failed for %d of %d files: %s", len(e.Errors), len(e.Results), strings.Join(msgs, "; "))
+}
+
+func (e *MultiUploadError) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, err := range e.Errors {
+		errs[i] = err
+	}
+	return errs
+}
+
+// UploadFiles 在一个请求中将多个本地文件上传到 destDir 目录，onConflict 为目标文件已存在时的处理方式：
+// overwrite（默认）、skip、rename、fail。有文件保存失败时返回 *MultiUploadError，其余文件仍然保存
+func (fs *HttpFs) UploadFiles(destDir string, srcFilePaths []string, onConflict string) ([]UploadResult, error) {
+	body, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+	go func() {
+		writer.CloseWithError(writeUploadForm(form, srcFilePaths, onConflict))
+	}()
+
+	req, err := http.NewRequest("POST", fs.BaseURL+cleanPath(destDir), body)
+	if err != nil {
+		body.Close()
+		return nil, fmt.Errorf("failed to create POST request: %w", err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	fs.setAuth(req)
+
+	resp, err := fs.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	return readUploadResponse(resp)
+}
+
+// writeUploadForm 写入上传表单，每个文件使用 files 字段
+func writeUploadForm(form *multipart.Writer, srcFilePaths []string, onConflict string) error {
+	if onConflict != "" {
+		if err := form.WriteField("onConflict", onConflict); err != nil {
+			return err
+		}
+	}
+	for _, name := range srcFilePaths {
+		file, err := os.Open(name)
+		if err != nil {
+			return fmt.Errorf("failed to open source file: %w", err)
+		}
+		part, err := form.CreateFormFile("files", filepath.Base(name))
+		if err == nil {
+			_, err = io.Copy(part, file)
+		}
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("failed to copy file content: %w", err)
+		}
+	}
+	return form.Close()
+}
+
+// readUploadResponse 解析表单上传的响应，有文件保存失败时返回 *MultiUploadError。
+// 旧版本的服务端只返回 "200 ok"，此时返回空的结果
+func readUploadResponse(resp *http.Response) ([]UploadResult, error) {
+	var result struct {
+		Files []UploadResult `json:"files"`
+	}
+	data, _ := io.ReadAll(resp.Body)
+	if err := json.Unmarshal(data, &result); err != nil || result.Files == nil {
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("upload failed with status: %s: %s", resp.Status, strings.TrimSpace(string(data)))
+		}
+		return nil, nil
+	}
+
+	uploadErr := &MultiUploadError{StatusCode: resp.StatusCode, Results: result.Files}
+	for _, file := range result.Files {
+		if file.Error != "" {
+			uploadErr.Errors = append(uploadErr.Errors, &FileError{Name: file.Name, Err: errors.New(file.Error)})
+		}
+	}
+	if len(uploadErr.Errors) > 0 {
+		return result.Files, uploadErr
+	}
+	if resp.StatusCode != http.StatusOK {
+		return result.Files, fmt.Errorf("upload failed with status: %s", resp.Status)
+	}
+	return result.Files, nil
+}
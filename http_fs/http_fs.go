@@ -1,14 +1,15 @@
 package http_fs
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
@@ -28,14 +29,71 @@ type FileInfo struct {
 }
 
 type DownloadResponse struct {
-	TaskId string `json:"taskId"`
+	TaskId   string `json:"taskId"`
+	Filename string `json:"filename"`
+}
+
+// ShareOptions 创建分享链接时的可选参数
+type ShareOptions struct {
+	// Expires 有效期，为 0 时使用服务端的默认值（24 小时）
+	Expires time.Duration
+	// MaxDownloads 最大下载次数，0 表示不限制
+	MaxDownloads int
+	// Password 访问密码，通过基础认证的密码输入，为空时不需要密码
+	Password string
+}
+
+// ShareLink 分享链接
+type ShareLink struct {
+	Id           string    `json:"id"`
+	Path         string    `json:"path"`
+	IsDir        bool      `json:"isDir"`
+	CreatedAt    time.Time `json:"createdAt"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+	MaxDownloads int       `json:"maxDownloads,omitempty"`
+	Downloads    int       `json:"downloads"`
+	HasPassword  bool      `json:"hasPassword"`
+	// Url 分享链接的完整地址
+	Url string `json:"url"`
+}
+
+// DownloadTaskOptions 添加下载任务时的可选参数
+type DownloadTaskOptions struct {
+	// Name 保存的文件名，为空时由服务端根据 URL 决定
+	Name string
+	// OnConflict 目标文件已存在时的处理方式："overwrite"（默认）、"rename"（追加数字后缀）、"fail"
+	OnConflict string
+	// Priority 任务优先级，越大越先开始
+	Priority int
+	// SHA256、SHA1、MD5 为可选的期望校验值（十六进制），不一致时任务失败
+	SHA256 string
+	SHA1   string
+	MD5    string
+	// DeleteOnMismatch 校验失败时删除下载的文件
+	DeleteOnMismatch bool
+	// 以下为服务端访问下载源时使用的参数，不会在任务列表中返回
+	Headers        map[string]string
+	Cookies        map[string]string
+	RemoteUsername string
+	RemotePassword string
+	UserAgent      string
+	Proxy          string // http、https 或 socks5 代理地址
+	// MaxAttempts 包括第一次在内的最多尝试次数，RetryBackoff 为第一次重试前的等待时间，零值使用服务端的默认值
+	MaxAttempts  int
+	RetryBackoff time.Duration
+	// RateLimit 任务的下载限速（字节/秒），0 表示只受服务端全局限速约束
+	RateLimit int64
 }
 
 type DownloadTaskInfo struct {
-	TaskId   string          `json:"taskId"`
-	Url      string          `json:"url"`
-	Filename string          `json:"filename"`
-	Status   *DownloadStatus `json:"status"`
+	TaskId    string            `json:"taskId"`
+	Url       string            `json:"url"`
+	Filename  string            `json:"filename"`
+	Status    *DownloadStatus   `json:"status"`
+	Checksums map[string]string `json:"checksums"` // 下载完成后计算出的校验值
+	// AttemptCount 为总的运行次数，Retries 为当前已自动重试的次数
+	AttemptCount int `json:"attemptCount"`
+	Retries      int `json:"retries"`
 }
 
 type DownloadStatus struct {
@@ -44,6 +102,7 @@ type DownloadStatus struct {
 	Downloaded uint64 `json:"downloaded"`
 	Speed      string `json:"speed"`
 	ErrMsg     string `json:"errMsg"`
+	RateLimit  int64  `json:"rateLimit"` // 实际生效的限速（字节/秒），0 表示不限速
 }
 
 // HttpFsOption 配置选项
@@ -65,7 +124,11 @@ type HttpFs struct {
 	Client   *http.Client
 	username string            // 基础认证用户名
 	password string            // 基础认证密码
+	token    string            // API 令牌，设置后代替基础认证
 	headers  map[string]string // 自定义请求头
+
+	chunkThreshold int64 // CreateFile 使用分块上传的文件大小，0 为默认值，负数时不使用
+	chunkSize      int64 // 分块上传每个分块的大小，0 为默认值
 }
 
 func NewHttpFs(baseURL string) *HttpFs {
@@ -113,6 +176,13 @@ func WithAuth(username, password string) HttpFsOption {
 	}
 }
 
+// WithToken 设置 API 令牌，以 Authorization: Bearer 头发送
+func WithToken(token string) HttpFsOption {
+	return func(fs *HttpFs) {
+		fs.token = token
+	}
+}
+
 // WithHeaders 设置自定义请求头
 func WithHeaders(headers map[string]string) HttpFsOption {
 	return func(fs *HttpFs) {
@@ -131,11 +201,38 @@ func (fs *HttpFs) SetAuth(username, password string) {
 	fs.password = password
 }
 
+// SetToken 设置 API 令牌，以 Authorization: Bearer 头发送
+func (fs *HttpFs) SetToken(token string) {
+	fs.token = token
+}
+
 // SetHeaders 设置自定义请求头
 func (fs *HttpFs) SetHeaders(headers map[string]string) {
 	fs.headers = headers
 }
 
+// setAuth 为请求添加认证信息和自定义头
+func (fs *HttpFs) setAuth(req *http.Request) {
+	if fs.token != "" {
+		req.Header.Set("Authorization", "Bearer "+fs.token)
+	} else if fs.username != "" && fs.password != "" {
+		req.SetBasicAuth(fs.username, fs.password)
+	}
+	for k, v := range fs.headers {
+		req.Header.Set(k, v)
+	}
+}
+
+// get 发送带认证信息的 GET 请求
+func (fs *HttpFs) get(url string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	fs.setAuth(req)
+	return fs.Client.Do(req)
+}
+
 // cleanPath cleans and normalizes a given path
 func cleanPath(p string) string {
 	return filepath.ToSlash(filepath.Clean(p))
@@ -165,15 +262,8 @@ func (fs *HttpFs) doRequest(method, url string, body interface{}, result interfa
 		req.Header.Set("Content-Type", "application/json")
 	}
 	
-	// 添加基础认证
-	if fs.username != "" && fs.password != "" {
-		req.SetBasicAuth(fs.username, fs.password)
-	}
-	
-	// 添加自定义头
-	for k, v := range fs.headers {
-		req.Header.Set(k, v)
-	}
+	// 添加认证信息和自定义头
+	fs.setAuth(req)
 	
 	resp, err := fs.Client.Do(req)
 	if err != nil {
@@ -303,7 +393,7 @@ func (fs *HttpFs) DownloadFile(srcPath, destPath string) error {
 	// srcPath is the uri of the file to download
 	// destPath is the local path to save the file
 	url := fs.BaseURL + srcPath
-	resp, err := fs.Client.Get(url)
+	resp, err := fs.get(url)
 	if err != nil {
 		return fmt.Errorf("failed to download file: %w", err)
 	}
@@ -364,8 +454,12 @@ func (fs *HttpFs) DownloadDir(srcPath, destPath string) error {
 	return nil
 }
 
-// CreateFile uploads a file to the specified directory
+// CreateFile uploads a file to the specified directory,
+// large files are sent in resumable chunks (see WithChunkedUpload)
 func (fs *HttpFs) CreateFile(destPath, srcFilePath string) error {
+	if stat, err := os.Stat(srcFilePath); err == nil && fs.useChunkedUpload(stat.Size()) {
+		return fs.UploadFileResumable(uploadTarget(destPath, srcFilePath), srcFilePath, nil)
+	}
 	return fs.uploadFileFromReader(destPath, srcFilePath, nil)
 }
 
@@ -414,6 +508,7 @@ func (fs *HttpFs) uploadFileFromReader(destPath, fileName string, reader io.Read
 		return fmt.Errorf("failed to create POST request: %w", err)
 	}
 	req.Header.Set("Content-Type", writer.FormDataContentType())
+	fs.setAuth(req)
 
 	resp, err := fs.Client.Do(req)
 	if err != nil {
@@ -421,11 +516,9 @@ func (fs *HttpFs) uploadFileFromReader(destPath, fileName string, reader io.Read
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("upload failed with status: %s", resp.Status)
-	}
-
-	return nil
+	// 保存失败时返回 *MultiUploadError
+	_, err = readUploadResponse(resp)
+	return err
 }
 
 // CreateFileFromUrl creates a file on the server from a URL
@@ -438,18 +531,50 @@ func (fs *HttpFs) CreateFileFromUrl(destPath, url string) error {
 
 // AddDownloadTask adds a new download task
 func (fs *HttpFs) AddDownloadTask(path, url, name string) (string, error) {
+	result, err := fs.AddDownloadTaskWithOptions(path, url, DownloadTaskOptions{Name: name})
+	if err != nil {
+		return "", err
+	}
+	return result.TaskId, nil
+}
+
+// AddDownloadTaskWithOptions 使用选项添加下载任务，返回任务 ID 和服务端最终使用的文件名
+func (fs *HttpFs) AddDownloadTaskWithOptions(path, url string, opts DownloadTaskOptions) (*DownloadResponse, error) {
 	destPath := cleanPath(path)
-	reqBody := map[string]string{
-		"method": "download",
-		"url":    url,
-		"name":   name,
+	reqBody := map[string]interface{}{
+		"method":           "download",
+		"url":              url,
+		"name":             opts.Name,
+		"onConflict":       opts.OnConflict,
+		"priority":         opts.Priority,
+		"sha256":           opts.SHA256,
+		"sha1":             opts.SHA1,
+		"md5":              opts.MD5,
+		"deleteOnMismatch": opts.DeleteOnMismatch,
+		"headers":          opts.Headers,
+		"cookies":          opts.Cookies,
+		"userAgent":        opts.UserAgent,
+		"proxy":            opts.Proxy,
+		"rateLimit":        opts.RateLimit,
+	}
+	if opts.RemoteUsername != "" || opts.RemotePassword != "" {
+		reqBody["basicAuth"] = map[string]string{
+			"username": opts.RemoteUsername,
+			"password": opts.RemotePassword,
+		}
+	}
+	if opts.MaxAttempts > 0 || opts.RetryBackoff > 0 {
+		reqBody["retry"] = map[string]interface{}{
+			"maxAttempts": opts.MaxAttempts,
+			"backoff":     opts.RetryBackoff.String(),
+		}
 	}
 	var result DownloadResponse
 	err := fs.doRequest("POST", fs.BaseURL+destPath, reqBody, &result)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return result.TaskId, nil
+	return &result, nil
 }
 
 // GetDownloadTaskStatus retrieves the status of a specific download task
@@ -485,6 +610,157 @@ func (fs *HttpFs) ListDownloadTasks(taskIds []string, status string) ([]Download
 	return result.Tasks, nil
 }
 
+// PauseDownloadTask 暂停下载任务，已下载的部分会保留
+func (fs *HttpFs) PauseDownloadTask(taskId string) error {
+	return fs.downloadTaskOperation("pauseTask", taskId)
+}
+
+// ResumeDownloadTask 继续已暂停或被中断的下载任务
+func (fs *HttpFs) ResumeDownloadTask(taskId string) error {
+	return fs.downloadTaskOperation("resumeTask", taskId)
+}
+
+// CancelDownloadTask 取消下载任务并删除已下载的部分文件
+func (fs *HttpFs) CancelDownloadTask(taskId string) error {
+	return fs.downloadTaskOperation("cancelTask", taskId)
+}
+
+// RetryDownloadTask 重新开始失败的下载任务
+func (fs *HttpFs) RetryDownloadTask(taskId string) error {
+	return fs.downloadTaskOperation("retryTask", taskId)
+}
+
+// ClearDownloadTasks 删除结束超过 olderThan 的任务记录，status 可以为 finished、failed、canceled 或空（全部）。
+// 非管理员只能删除自己添加的任务，返回删除的数量
+func (fs *HttpFs) ClearDownloadTasks(status string, olderThan time.Duration) (int, error) {
+	reqBody := map[string]interface{}{
+		"method":    "clearTasks",
+		"status":    status,
+		"olderThan": olderThan.String(),
+	}
+	var result struct {
+		Removed int `json:"removed"`
+	}
+	if err := fs.doRequest("POST", fs.BaseURL+"/", reqBody, &result); err != nil {
+		return 0, err
+	}
+	return result.Removed, nil
+}
+
+// WatchDownloadTasks 通过服务端推送（SSE）订阅下载任务的状态变化，taskIds 为空时订阅所有任务。
+// 连接后会先收到任务的当前状态，ctx 取消或连接断开时关闭返回的 channel
+func (fs *HttpFs) WatchDownloadTasks(ctx context.Context, taskIds ...string) (<-chan DownloadTaskInfo, error) {
+	query := url.Values{}
+	for _, taskId := range taskIds {
+		query.Add("taskId", taskId)
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", fs.BaseURL+"/:tasks?"+query.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	fs.setAuth(req)
+
+	// 推送连接会一直保持，不能使用带超时的客户端
+	client := *fs.Client
+	client.Timeout = 0
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("request failed with status: %s", resp.Status)
+	}
+
+	updates := make(chan DownloadTaskInfo)
+	go func() {
+		defer close(updates)
+		defer resp.Body.Close()
+
+		var event, data string
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == "":
+				if event == "task" {
+					var task DownloadTaskInfo
+					if err := json.Unmarshal([]byte(data), &task); err == nil {
+						select {
+						case updates <- task:
+						case <-ctx.Done():
+							return
+						}
+					}
+				}
+				event, data = "", ""
+			case strings.HasPrefix(line, "event:"):
+				event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+			case strings.HasPrefix(line, "data:"):
+				if data != "" {
+					data += "\n"
+				}
+				data += strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " ")
+			}
+		}
+	}()
+	return updates, nil
+}
+
+func (fs *HttpFs) downloadTaskOperation(method, taskId string) error {
+	reqBody := map[string]string{
+		"method": method,
+		"taskId": taskId,
+	}
+	return fs.doRequest("POST", fs.BaseURL+"/", reqBody, nil)
+}
+
+// CreateShareLink 为文件或目录创建带签名和有效期的分享链接，持有链接的人不需要登录即可下载
+func (fs *HttpFs) CreateShareLink(path string, opts ShareOptions) (*ShareLink, error) {
+	url := fs.BaseURL + cleanPath(filepath.Dir(path))
+	reqBody := map[string]interface{}{
+		"method":       "share",
+		"name":         filepath.Base(path),
+		"expires":      opts.Expires.String(),
+		"maxDownloads": opts.MaxDownloads,
+		"password":     opts.Password,
+	}
+	var link ShareLink
+	if err := fs.doRequest("POST", url, reqBody, &link); err != nil {
+		return nil, err
+	}
+	link.Url = fs.BaseURL + link.Url
+	return &link, nil
+}
+
+// ListShareLinks 列出当前用户创建的仍然有效的分享链接
+func (fs *HttpFs) ListShareLinks() ([]ShareLink, error) {
+	reqBody := map[string]string{
+		"method": "listShares",
+	}
+	var result struct {
+		Shares []ShareLink `json:"shares"`
+	}
+	if err := fs.doRequest("POST", fs.BaseURL+"/", reqBody, &result); err != nil {
+		return nil, err
+	}
+	for i := range result.Shares {
+		result.Shares[i].Url = fs.BaseURL + result.Shares[i].Url
+	}
+	return result.Shares, nil
+}
+
+// RevokeShareLink 撤销分享链接
+func (fs *HttpFs) RevokeShareLink(id string) error {
+	reqBody := map[string]string{
+		"method":  "revokeShare",
+		"shareId": id,
+	}
+	return fs.doRequest("POST", fs.BaseURL+"/", reqBody, nil)
+}
+
 // Exists 检查文件或目录是否存在
 func (fs *HttpFs) Exists(path string) (bool, error) {
 	_, err := fs.Stat(path)
@@ -497,16 +773,36 @@ func (fs *HttpFs) Exists(path string) (bool, error) {
 	return true, nil
 }
 
-// Rename 重命名文件或目录
+// Rename 重命名文件或目录，newPath 已存在时失败
 func (fs *HttpFs) Rename(oldPath, newPath string) error {
-	// 需要服务端支持 MOVE 方法或自定义 API
-	return errors.New("rename not implemented - requires server support")
+	return fs.Move(oldPath, newPath, false)
+}
+
+// Move 将文件或目录移动到 destPath（可以在其它目录中），destPath 已存在时只有 overwrite 为 true 才替换
+func (fs *HttpFs) Move(srcPath, destPath string, overwrite bool) error {
+	return fs.transfer("move", srcPath, destPath, overwrite)
+}
+
+// Copy 在服务端将文件或目录复制到 destPath，destPath 已存在时只有 overwrite 为 true 才替换
+func (fs *HttpFs) Copy(srcPath, destPath string, overwrite bool) error {
+	return fs.transfer("copy", srcPath, destPath, overwrite)
+}
+
+func (fs *HttpFs) transfer(method, srcPath, destPath string, overwrite bool) error {
+	url := fs.BaseURL + cleanPath(filepath.Dir(srcPath))
+	reqBody := map[string]interface{}{
+		"method":    method,
+		"name":      filepath.Base(srcPath),
+		"dest":      cleanPath(destPath),
+		"overwrite": overwrite,
+	}
+	return fs.doRequest("POST", url, reqBody, nil)
 }
 
 // GetFileReader 获取文件内容的 io.ReadCloser
 func (fs *HttpFs) GetFileReader(path string) (io.ReadCloser, error) {
 	url := fs.BaseURL + cleanPath(path)
-	resp, err := fs.Client.Get(url)
+	resp, err := fs.get(url)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get file reader: %w", err)
 	}
@@ -530,11 +826,9 @@ func (fs *HttpFs) GetFileContent(path string) ([]byte, error) {
 	return io.ReadAll(reader)
 }
 
-// UploadWithProgress 带进度回调的上传
+// UploadWithProgress 带进度回调的上传，使用分块上传，每发送完一个分块回调一次
 func (fs *HttpFs) UploadWithProgress(destPath, srcFilePath string, progress func(bytesRead, totalBytes int64)) error {
-	// 实现带进度的上传
-	// 需要包装 io.Reader 来追踪读取进度
-	return fs.CreateFile(destPath, srcFilePath)
+	return fs.UploadFileResumable(uploadTarget(destPath, srcFilePath), srcFilePath, progress)
 }
 
 // DownloadWithProgress 带进度回调的下载
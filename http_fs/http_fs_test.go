@@ -1,9 +1,16 @@
 package http_fs
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -14,7 +21,7 @@ func createMockServer(t *testing.T) *httptest.Server {
 
 	// 模拟文件列表 API
 	mux.HandleFunc("/test-dir", func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Query().Get("json") != "" {
+		if r.URL.Query().Has("json") {
 			files := []FileInfo{
 				{
 					Name:       "file1.txt",
@@ -79,7 +86,7 @@ func createMockServer(t *testing.T) *httptest.Server {
 
 	// 根目录
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Query().Get("json") != "" {
+		if r.URL.Query().Has("json") {
 			files := []FileInfo{
 				{
 					Name:  "test-dir",
@@ -173,6 +180,73 @@ func TestNewHttpFsWithOptions(t *testing.T) {
 	}
 }
 
+// TestWithToken 测试所有请求都带上 API 令牌
+func TestWithToken(t *testing.T) {
+	var auths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auths = append(auths, r.Header.Get("Authorization"))
+		if r.Method == "GET" {
+			w.Write([]byte("[]"))
+		}
+	}))
+	defer server.Close()
+
+	fs := NewHttpFsWithOptions(server.URL, WithToken("abc.def"))
+	if _, err := fs.ListFiles("/"); err != nil {
+		t.Fatalf("ListFiles failed: %v", err)
+	}
+	if err := fs.CreateFileFromBytes("/a.txt", []byte("a")); err != nil {
+		t.Fatalf("CreateFileFromBytes failed: %v", err)
+	}
+	if _, err := fs.GetFileContent("/a.txt"); err != nil {
+		t.Fatalf("GetFileContent failed: %v", err)
+	}
+
+	if len(auths) != 3 {
+		t.Fatalf("got %d requests, want 3", len(auths))
+	}
+	for i, auth := range auths {
+		if auth != "Bearer abc.def" {
+			t.Errorf("request %d: Authorization = %q, want Bearer abc.def", i, auth)
+		}
+	}
+}
+
+// TestCreateShareLink 测试创建分享链接
+func TestCreateShareLink(t *testing.T) {
+	var got map[string]interface{}
+	var gotPath string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&got)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":           "abc",
+			"maxDownloads": 3,
+			"hasPassword":  true,
+			"url":          "/:share/abc.1.sig/report.pdf",
+		})
+	}))
+	defer server.Close()
+
+	fs := NewHttpFs(server.URL)
+	link, err := fs.CreateShareLink("/docs/report.pdf", ShareOptions{
+		Expires:      2 * time.Hour,
+		MaxDownloads: 3,
+		Password:     "pw",
+	})
+	if err != nil {
+		t.Fatalf("CreateShareLink failed: %v", err)
+	}
+
+	if gotPath != "/docs" || got["method"] != "share" || got["name"] != "report.pdf" ||
+		got["expires"] != "2h0m0s" || got["maxDownloads"] != float64(3) || got["password"] != "pw" {
+		t.Errorf("request = %s %v", gotPath, got)
+	}
+	if link.Id != "abc" || !link.HasPassword || link.Url != server.URL+"/:share/abc.1.sig/report.pdf" {
+		t.Errorf("link = %+v", link)
+	}
+}
+
 // TestListFiles 测试列出文件
 func TestListFiles(t *testing.T) {
 	server := createMockServer(t)
@@ -215,3 +289,302 @@ func TestListFiles(t *testing.T) {
 		t.Error("subdir not found")
 	}
 }
+
+// TestDownloadTaskOperations 测试暂停、继续和取消下载任务
+func TestDownloadTaskOperations(t *testing.T) {
+	var gotMethods []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if req["taskId"] != "task-123" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		gotMethods = append(gotMethods, req["method"])
+		json.NewEncoder(w).Encode(DownloadResponse{TaskId: req["taskId"]})
+	}))
+	defer server.Close()
+
+	fs := NewHttpFs(server.URL)
+	if err := fs.PauseDownloadTask("task-123"); err != nil {
+		t.Fatalf("PauseDownloadTask failed: %v", err)
+	}
+	if err := fs.ResumeDownloadTask("task-123"); err != nil {
+		t.Fatalf("ResumeDownloadTask failed: %v", err)
+	}
+	if err := fs.CancelDownloadTask("task-123"); err != nil {
+		t.Fatalf("CancelDownloadTask failed: %v", err)
+	}
+	if err := fs.RetryDownloadTask("task-123"); err != nil {
+		t.Fatalf("RetryDownloadTask failed: %v", err)
+	}
+	if err := fs.CancelDownloadTask("missing"); err == nil {
+		t.Error("CancelDownloadTask should fail for unknown task")
+	}
+
+	want := []string{"pauseTask", "resumeTask", "cancelTask", "retryTask"}
+	if strings.Join(gotMethods, ",") != strings.Join(want, ",") {
+		t.Errorf("methods = %v, want %v", gotMethods, want)
+	}
+}
+
+// TestAddDownloadTaskWithOptions 测试带文件名和冲突策略添加下载任务
+func TestAddDownloadTaskWithOptions(t *testing.T) {
+	var got map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(DownloadResponse{TaskId: "task-123", Filename: "file_1.zip"})
+	}))
+	defer server.Close()
+
+	fs := NewHttpFs(server.URL)
+	result, err := fs.AddDownloadTaskWithOptions("/downloads", "http://example.com/file.zip", DownloadTaskOptions{
+		Name:           "file.zip",
+		OnConflict:     "rename",
+		Priority:       2,
+		SHA256:         "abc",
+		RateLimit:      1024,
+		Headers:        map[string]string{"X-Token": "secret"},
+		RemoteUsername: "bob",
+		RemotePassword: "pass",
+	})
+	if err != nil {
+		t.Fatalf("AddDownloadTaskWithOptions failed: %v", err)
+	}
+	if result.TaskId != "task-123" || result.Filename != "file_1.zip" {
+		t.Errorf("result = %+v", result)
+	}
+	if got["method"] != "download" || got["name"] != "file.zip" || got["onConflict"] != "rename" || got["priority"] != float64(2) || got["sha256"] != "abc" || got["rateLimit"] != float64(1024) {
+		t.Errorf("request body = %v", got)
+	}
+	if headers, _ := got["headers"].(map[string]interface{}); headers["X-Token"] != "secret" {
+		t.Errorf("headers = %v", got["headers"])
+	}
+	if auth, _ := got["basicAuth"].(map[string]interface{}); auth["username"] != "bob" || auth["password"] != "pass" {
+		t.Errorf("basicAuth = %v", got["basicAuth"])
+	}
+}
+
+// TestClearDownloadTasks 测试清理已结束的任务记录
+func TestClearDownloadTasks(t *testing.T) {
+	var got map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"removed":3}`))
+	}))
+	defer server.Close()
+
+	fs := NewHttpFs(server.URL)
+	removed, err := fs.ClearDownloadTasks("finished", 24*time.Hour)
+	if err != nil {
+		t.Fatalf("ClearDownloadTasks failed: %v", err)
+	}
+	if removed != 3 {
+		t.Errorf("removed = %d, want 3", removed)
+	}
+	if got["method"] != "clearTasks" || got["status"] != "finished" || got["olderThan"] != "24h0m0s" {
+		t.Errorf("request body = %v", got)
+	}
+}
+
+// TestWatchDownloadTasks 测试通过服务端推送接收任务状态
+func TestWatchDownloadTasks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" || r.URL.Path != "/:tasks" || r.URL.Query().Get("taskId") != "task-123" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "event:task\ndata:{\"taskId\":\"task-123\",\"status\":{\"status\":\"downloading\"}}\n\n")
+		fmt.Fprint(w, "event:ping\ndata:1\n\n")
+		fmt.Fprint(w, "event:task\ndata:{\"taskId\":\"task-123\",\"status\":{\"status\":\"finished\"}}\n\n")
+	}))
+	defer server.Close()
+
+	fs := NewHttpFsWithOptions(server.URL, WithTimeout(time.Second))
+	updates, err := fs.WatchDownloadTasks(context.Background(), "task-123")
+	if err != nil {
+		t.Fatalf("WatchDownloadTasks failed: %v", err)
+	}
+
+	var statuses []string
+	for task := range updates {
+		if task.TaskId != "task-123" {
+			t.Errorf("taskId = %s", task.TaskId)
+		}
+		statuses = append(statuses, task.Status.Status)
+	}
+	if strings.Join(statuses, ",") != "downloading,finished" {
+		t.Errorf("statuses = %v", statuses)
+	}
+
+	if _, err := fs.WatchDownloadTasks(context.Background(), "missing"); err == nil {
+		t.Error("WatchDownloadTasks should fail on bad status")
+	}
+}
+
+func TestCreateFileChunked(t *testing.T) {
+	var (
+		received  []byte
+		failed    bool
+		committed string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/:uploads":
+			var req struct {
+				Path string `json:"path"`
+				Size int64  `json:"size"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			committed = req.Path
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(Upload{Id: "u1", Path: req.Path, Size: req.Size})
+		case r.Method == "GET" && r.URL.Path == "/:uploads/u1":
+			json.NewEncoder(w).Encode(Upload{Id: "u1", Size: 10, Offset: int64(len(received))})
+		case r.Method == "PATCH" && r.URL.Path == "/:uploads/u1":
+			if r.Header.Get("Upload-Offset") != fmt.Sprint(len(received)) {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			data, _ := io.ReadAll(r.Body)
+			// 第二个分块只接收一部分后失败，客户端应从服务端记录的位置续传
+			if len(received) == 4 && !failed {
+				failed = true
+				received = append(received, data[:2]...)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			received = append(received, data...)
+			w.Header().Set("Upload-Offset", fmt.Sprint(len(received)))
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == "POST" && r.URL.Path == "/:uploads/u1":
+			json.NewEncoder(w).Encode(Upload{Id: "u1", Size: 10, Offset: 10})
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	src := filepath.Join(t.TempDir(), "data.bin")
+	os.WriteFile(src, []byte("0123456789"), 0644)
+
+	fs := NewHttpFsWithOptions(server.URL, WithChunkedUpload(5, 4))
+	if err := fs.CreateFile("/docs/ignored.bin", src); err != nil {
+		t.Fatalf("CreateFile failed: %v", err)
+	}
+	if string(received) != "0123456789" || !failed {
+		t.Errorf("received %q, failed %v", received, failed)
+	}
+	if committed != "/docs/data.bin" {
+		t.Errorf("upload path = %s", committed)
+	}
+
+	// 服务端拒绝的上传不重试
+	fs = NewHttpFsWithOptions(server.URL+"/missing", WithChunkedUpload(1, 4))
+	var uploadErr *UploadError
+	if err := fs.UploadWithProgress("/docs/x", src, nil); err == nil || errors.As(err, &uploadErr) {
+		t.Errorf("UploadWithProgress should fail to create the upload, got %v", err)
+	}
+}
+
+// TestUploadFiles 测试多文件上传的结果和部分失败
+func TestUploadFiles(t *testing.T) {
+	var onConflict string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(10 << 20); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		onConflict = r.FormValue("onConflict")
+		var results []UploadResult
+		saved, failed := 0, 0
+		for _, file := range r.MultipartForm.File["files"] {
+			result := UploadResult{Name: file.Filename, Path: "/docs/" + file.Filename, Size: file.Size}
+			if file.Filename == "bad.txt" {
+				result.Error = "disk full"
+				failed++
+			} else {
+				saved++
+			}
+			results = append(results, result)
+		}
+		status := http.StatusOK
+		if failed > 0 && saved > 0 {
+			status = http.StatusMultiStatus
+		} else if failed > 0 {
+			status = http.StatusInternalServerError
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{"files": results})
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "bad.txt"} {
+		os.WriteFile(filepath.Join(dir, name), []byte(name), 0644)
+	}
+	fs := NewHttpFs(server.URL)
+
+	results, err := fs.UploadFiles("/docs", []string{filepath.Join(dir, "a.txt")}, "rename")
+	if err != nil || len(results) != 1 || results[0].Size != 5 || onConflict != "rename" {
+		t.Fatalf("UploadFiles() = %+v, %v, onConflict %q", results, err, onConflict)
+	}
+
+	results, err = fs.UploadFiles("/docs", []string{filepath.Join(dir, "a.txt"), filepath.Join(dir, "bad.txt")}, "")
+	var uploadErr *MultiUploadError
+	if !errors.As(err, &uploadErr) || uploadErr.StatusCode != http.StatusMultiStatus || len(results) != 2 {
+		t.Fatalf("partial failure: %+v, %v", results, err)
+	}
+	var fileErr *FileError
+	if len(uploadErr.Errors) != 1 || !errors.As(err, &fileErr) || fileErr.Name != "bad.txt" {
+		t.Errorf("unexpected file errors: %v", err)
+	}
+
+	// 单个文件的上传同样返回每个文件的错误
+	if err := fs.CreateFileFromBytes("/docs/bad.txt", []byte("x")); !errors.As(err, &uploadErr) || uploadErr.StatusCode != http.StatusInternalServerError {
+		t.Errorf("CreateFileFromBytes() = %v", err)
+	}
+}
+
+// TestMoveAndCopy 测试移动、复制和重命名的请求参数
+func TestMoveAndCopy(t *testing.T) {
+	var (
+		requestPath string
+		req         map[string]interface{}
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestPath = r.URL.Path
+		req = nil
+		json.NewDecoder(r.Body).Decode(&req)
+		if req["dest"] == "/exists.txt" && req["overwrite"] != true {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"path": req["dest"].(string)})
+	}))
+	defer server.Close()
+	fs := NewHttpFs(server.URL)
+
+	if err := fs.Move("/docs/a.txt", "/archive/2024/a.txt", false); err != nil {
+		t.Fatal(err)
+	}
+	if requestPath != "/docs" || req["method"] != "move" || req["name"] != "a.txt" || req["dest"] != "/archive/2024/a.txt" {
+		t.Errorf("unexpected move request %s %v", requestPath, req)
+	}
+	if err := fs.Copy("/docs", "/docs-backup", true); err != nil || req["method"] != "copy" || req["overwrite"] != true {
+		t.Errorf("Copy() = %v, request %v", err, req)
+	}
+	if err := fs.Rename("/a.txt", "/exists.txt"); err == nil {
+		t.Error("Rename should not replace an existing file")
+	}
+}
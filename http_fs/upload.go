@@ -0,0 +1,266 @@
+package http_fs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultChunkedUploadThreshold 文件达到该大小时 CreateFile 使用可续传的分块上传
+	DefaultChunkedUploadThreshold = 16 << 20
+	// DefaultChunkSize 分块上传每个请求发送的字节数
+	DefaultChunkSize = 8 << 20
+	// 分块连续失败的最多重试次数
+	maxChunkRetries = 5
+	// 第一次重试前的等待时间，之后每次加倍
+	chunkRetryBackoff = 500 * time.Millisecond
+)
+
+// Upload 服务端的可续传上传
+type Upload struct {
+	Id        string    `json:"id"`
+	Path      string    `json:"path"`
+	Size      int64     `json:"size"`
+	Offset    int64     `json:"offset"`
+	Sha256    string    `json:"sha256,omitempty"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// UploadError 分块上传在重试后仍然失败，可以使用 UploadId 调用 ResumeUpload 从中断的位置继续
+type UploadError struct {
+	UploadId string
+	// 服务端已接收的字节数
+	Offset int64
+	Err    error
+}
+
+func (e *UploadError) Error() string {
+	return fmt.Sprintf("upload %s failed at offset %d: %v", e.UploadId, e.Offset, e.Err)
+}
+
+func (e *UploadError) Unwrap() error {
+	return e.Err
+}
+
+// uploadStatusError 服务端以非预期的状态码响应了上传请求
+type uploadStatusError struct {
+	StatusCode int
+	Status     string
+	Message    string
+}
+
+func (e *uploadStatusError) Error() string {
+	return fmt.Sprintf("request failed with status: %s: %s", e.Status, e.Message)
+}
+
+// retryable 判断上传请求的错误能否通过重试解决：网络错误、服务端错误，以及分块位置与服务端不一致
+func retryable(err error) bool {
+	var statusErr *uploadStatusError
+	if !errors.As(err, &statusErr) {
+		return true
+	}
+	return statusErr.StatusCode >= 500 || statusErr.StatusCode == http.StatusConflict
+}
+
+// WithChunkedUpload 设置 CreateFile 使用分块上传的文件大小阈值和每个分块的大小，
+// threshold 为负数时不使用分块上传，为 0 时使用默认值
+func WithChunkedUpload(threshold, chunkSize int64) HttpFsOption {
+	return func(fs *HttpFs) {
+		fs.chunkThreshold = threshold
+		fs.chunkSize = chunkSize
+	}
+}
+
+// useChunkedUpload 判断 size 大小的文件是否使用分块上传
+func (fs *HttpFs) useChunkedUpload(size int64) bool {
+	threshold := fs.chunkThreshold
+	if threshold == 0 {
+		threshold = DefaultChunkedUploadThreshold
+	}
+	return threshold > 0 && size >= threshold
+}
+
+// uploadChunkSize 返回分块的大小
+func (fs *HttpFs) uploadChunkSize() int64 {
+	if fs.chunkSize > 0 {
+		return fs.chunkSize
+	}
+	return DefaultChunkSize
+}
+
+// uploadRequest 发送可续传上传的请求，状态码不是 wantStatus 时返回错误
+func (fs *HttpFs) uploadRequest(method, path string, body io.Reader, header http.Header, wantStatus int, result interface{}) (*http.Response, error) {
+	req, err := http.NewRequest(method, fs.BaseURL+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	fs.setAuth(req)
+
+	resp, err := fs.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != wantStatus {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return resp, &uploadStatusError{StatusCode: resp.StatusCode, Status: resp.Status, Message: string(bytes.TrimSpace(msg))}
+	}
+	if result != nil {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			return resp, err
+		}
+	}
+	return resp, nil
+}
+
+// CreateUpload 在服务端创建上传到 destPath 的可续传上传，sha256 不为空时服务端在完成上传时校验
+func (fs *HttpFs) CreateUpload(destPath string, size int64, sha256 string) (*Upload, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"path":   cleanPath(destPath),
+		"size":   size,
+		"sha256": sha256,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var upload Upload
+	header := http.Header{"Content-Type": {"application/json"}}
+	if _, err := fs.uploadRequest("POST", "/:uploads", bytes.NewReader(body), header, http.StatusCreated, &upload); err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+// GetUpload 查询可续传上传，Offset 为服务端已接收的字节数
+func (fs *HttpFs) GetUpload(uploadId string) (*Upload, error) {
+	var upload Upload
+	if _, err := fs.uploadRequest("GET", "/:uploads/"+url.PathEscape(uploadId), nil, nil, http.StatusOK, &upload); err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+// UploadChunk 从 offset 开始发送 length 字节的分块，返回服务端已接收的字节数
+func (fs *HttpFs) UploadChunk(uploadId string, offset int64, data io.Reader, length int64) (int64, error) {
+	header := http.Header{
+		"Content-Type":  {"application/octet-stream"},
+		"Upload-Offset": {strconv.FormatInt(offset, 10)},
+	}
+	body := io.LimitReader(data, length)
+	resp, err := fs.uploadRequest("PATCH", "/:uploads/"+url.PathEscape(uploadId), body, header, http.StatusNoContent, nil)
+	if resp != nil {
+		if received, parseErr := strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64); parseErr == nil {
+			return received, err
+		}
+	}
+	return offset, err
+}
+
+// CommitUpload 在发送完所有数据后完成上传，服务端将文件移动到目标路径
+func (fs *HttpFs) CommitUpload(uploadId string) error {
+	_, err := fs.uploadRequest("POST", "/:uploads/"+url.PathEscape(uploadId), nil, nil, http.StatusOK, nil)
+	return err
+}
+
+// CancelUpload 取消上传，服务端删除已接收的数据
+func (fs *HttpFs) CancelUpload(uploadId string) error {
+	_, err := fs.uploadRequest("DELETE", "/:uploads/"+url.PathEscape(uploadId), nil, nil, http.StatusNoContent, nil)
+	return err
+}
+
+// UploadFileResumable 使用分块上传将本地文件上传到 destPath，分块失败时从服务端已接收的位置重试。
+// 重试后仍然失败时返回 *UploadError，可以使用其中的 UploadId 调用 ResumeUpload 继续
+func (fs *HttpFs) UploadFileResumable(destPath, srcFilePath string, progress func(bytesSent, totalBytes int64)) error {
+	file, err := os.Open(srcFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to open source file: %w", err)
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	upload, err := fs.CreateUpload(destPath, stat.Size(), "")
+	if err != nil {
+		return fmt.Errorf("failed to create upload: %w", err)
+	}
+	return fs.sendUpload(upload, file, progress)
+}
+
+// ResumeUpload 从服务端已接收的位置继续上传本地文件并完成上传
+func (fs *HttpFs) ResumeUpload(uploadId, srcFilePath string, progress func(bytesSent, totalBytes int64)) error {
+	file, err := os.Open(srcFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to open source file: %w", err)
+	}
+	defer file.Close()
+
+	upload, err := fs.GetUpload(uploadId)
+	if err != nil {
+		return err
+	}
+	if stat, err := file.Stat(); err != nil {
+		return err
+	} else if stat.Size() != upload.Size {
+		return fmt.Errorf("source file has %d bytes, upload expects %d", stat.Size(), upload.Size)
+	}
+	return fs.sendUpload(upload, file, progress)
+}
+
+// sendUpload 从 upload.Offset 开始发送文件剩余的数据并完成上传
+func (fs *HttpFs) sendUpload(upload *Upload, file io.ReaderAt, progress func(bytesSent, totalBytes int64)) error {
+	chunkSize := fs.uploadChunkSize()
+	offset := upload.Offset
+	failures := 0
+	for offset < upload.Size {
+		if progress != nil {
+			progress(offset, upload.Size)
+		}
+		length := min(chunkSize, upload.Size-offset)
+		received, err := fs.UploadChunk(upload.Id, offset, io.NewSectionReader(file, offset, length), length)
+		if err == nil {
+			offset = received
+			failures = 0
+			continue
+		}
+
+		failures++
+		if failures > maxChunkRetries || !retryable(err) {
+			return &UploadError{UploadId: upload.Id, Offset: offset, Err: err}
+		}
+		time.Sleep(chunkRetryBackoff << (failures - 1))
+		// 中断的请求可能已经写入了一部分，从服务端记录的位置继续
+		if current, getErr := fs.GetUpload(upload.Id); getErr == nil {
+			offset = current.Offset
+		} else {
+			offset = received
+		}
+	}
+	if progress != nil {
+		progress(offset, upload.Size)
+	}
+
+	if err := fs.CommitUpload(upload.Id); err != nil {
+		return &UploadError{UploadId: upload.Id, Offset: offset, Err: err}
+	}
+	return nil
+}
+
+// uploadTarget 返回 CreateFile 上传 srcFilePath 的目标路径：destPath 所在的目录加上源文件名
+func uploadTarget(destPath, srcFilePath string) string {
+	return path.Join(cleanPath(filepath.Dir(destPath)), filepath.Base(srcFilePath))
+}
@@ -1,19 +1,27 @@
 package main
 
 import (
+	"crypto/tls"
 	"embed"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"mime"
+	"mime/multipart"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/breezechen/go_file_server/auth"
+	"github.com/breezechen/go_file_server/quota"
 	"github.com/breezechen/go_file_server/webdav/server"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -21,6 +29,13 @@ import (
 	"github.com/urfave/cli/v2"
 )
 
+const (
+	// 清理已结束任务记录的检查间隔
+	janitorInterval = time.Hour
+	// 检查用户文件是否被修改的间隔
+	usersFileCheckInterval = 2 * time.Second
+)
+
 var (
 	//go:embed favicon.svg
 	favicon []byte
@@ -33,14 +48,44 @@ var (
 
 	manager = NewDownloadManager()
 
+	ErrTaskNotFound     = errors.New("task not found")
+	ErrFileExists       = errors.New("file already exists")
+	ErrBadRequest       = errors.New("bad request")
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+
 	rootDir string
 )
 
 type PostRequest struct {
-	Url    string   `json:"url"`
-	Method string   `json:"method"`
-	Name   string   `json:"name"`
-	Logs   []string `json:"logs"`
+	Url        string   `json:"url"`
+	Method     string   `json:"method"`
+	Name       string   `json:"name"`
+	Logs       []string `json:"logs"`
+	TaskId     string   `json:"taskId"`
+	Priority   int      `json:"priority"`
+	OnConflict string   `json:"onConflict"`
+	// 可选的校验值，下载完成后校验
+	Sha256           string `json:"sha256"`
+	Sha1             string `json:"sha1"`
+	Md5              string `json:"md5"`
+	DeleteOnMismatch bool   `json:"deleteOnMismatch"`
+	// 访问下载源时使用的请求头、Cookie、认证和代理
+	RemoteOptions
+	// 可选的重试策略，未设置的字段使用全局策略
+	Retry *RetryPolicy `json:"retry"`
+	// 可选的限速（字节/秒），0 表示只受全局限速约束
+	RateLimit int64 `json:"rateLimit"`
+	// clearTasks 的过滤条件：任务状态和结束时长
+	Status    string   `json:"status"`
+	OlderThan Duration `json:"olderThan"`
+	// share 的参数：有效期、最大下载次数和访问密码；revokeShare 的分享 ID
+	Expires      Duration `json:"expires"`
+	MaxDownloads int      `json:"maxDownloads"`
+	Password     string   `json:"password"`
+	ShareId      string   `json:"shareId"`
+	// move 和 copy 的参数：相对用户根目录的目标路径，目标已存在时是否替换
+	Dest      string `json:"dest"`
+	Overwrite bool   `json:"overwrite"`
 }
 
 type DownloadResponse struct {
@@ -48,11 +93,37 @@ type DownloadResponse struct {
 	Filename string `json:"filename"`
 }
 
+// UploadResult 表单上传中一个文件的结果
+type UploadResult struct {
+	// 上传时的文件名
+	Name string `json:"name"`
+	// 相对用户根目录的保存路径，rename 策略下可能与文件名不同
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256,omitempty"`
+	// 目标文件已存在，按 skip 策略没有保存
+	Skipped bool   `json:"skipped,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+type UploadResponse struct {
+	Files []UploadResult `json:"files"`
+}
+
+type ClearTasksResponse struct {
+	Removed int `json:"removed"`
+}
+
 type CreateDirResponse struct {
 	Name string `json:"name"`
 	Url  string `json:"url"`
 }
 
+// MoveResponse move 和 copy 的结果，Path 为相对用户根目录的目标路径
+type MoveResponse struct {
+	Path string `json:"path"`
+}
+
 type ListTaskRequestItem struct {
 	TaskIds []string `json:"taskIds"`
 	Status  string   `json:"status"`
@@ -72,6 +143,8 @@ type DownloadStatus struct {
 	Downloaded uint64 `json:"downloaded"`
 	Speed      string `json:"speed"`
 	ErrMsg     string `json:"errMsg"`
+	// RateLimit 为任务当前实际的限速（字节/秒），0 表示不限速
+	RateLimit int64 `json:"rateLimit,omitempty"`
 }
 
 type DownloadTaskInfo struct {
@@ -79,30 +152,172 @@ type DownloadTaskInfo struct {
 	Url       string          `json:"url"`
 	Filename  string          `json:"filename"`
 	Filepath  string          `json:"filepath"`
+	Priority  int             `json:"priority"`
 	Status    *DownloadStatus `json:"status"`
 	StartedAt *time.Time      `json:"startedAt"`
 	EndAt     *time.Time      `json:"endAt"`
+	// 期望的校验值和下载完成后计算出的校验值，键为算法名
+	ExpectedChecksums map[string]string `json:"expectedChecksums,omitempty"`
+	Checksums         map[string]string `json:"checksums,omitempty"`
+	DeleteOnMismatch  bool              `json:"deleteOnMismatch,omitempty"`
+	// Remote 可能包含凭据，只保存在状态目录中，不在接口中返回
+	Remote *RemoteOptions `json:"-"`
+	// Retry 为任务自己的重试策略，为空时使用全局策略
+	Retry *RetryPolicy `json:"retry,omitempty"`
+	// RateLimit 为任务自己的限速（字节/秒），0 表示只受全局限速约束
+	RateLimit int64 `json:"rateLimit,omitempty"`
+	// Owner 为添加任务的用户，下载的文件计入该用户的配额
+	Owner string `json:"owner,omitempty"`
+	// Retries 为自动重试的次数，手动重试时清零
+	Retries     int        `json:"retries"`
+	NextRetryAt *time.Time `json:"nextRetryAt,omitempty"`
+	// AttemptCount 为总的运行次数，Attempts 只保留最近的记录
+	AttemptCount int               `json:"attemptCount"`
+	Attempts     []DownloadAttempt `json:"attempts,omitempty"`
 }
 
 type DownloadManager struct {
 	Tasks             map[string]*DownloadTaskInfo
-	taskToDownloadMap map[string]*got.Download
+	jobs              map[string]*downloadJob
 	downloadToTaskMap map[*got.Download]string
+	store             *TaskStore
+	// 同时进行的下载数上限，0 表示不限制
+	maxConcurrent int
+	active        int
+	queue         []*queuedJob
+	// 全局重试策略和等待中的自动重试
+	retryPolicy RetryPolicy
+	retryTimers map[string]*time.Timer
+	// 订阅任务状态变化的 TaskWatcher
+	watchers map[*TaskWatcher]struct{}
+	// 所有任务共享的全局限速
+	rateLimiter *rateLimiter
+	// 下载的文件大小确定后检查的配额，为空时不限制
+	quota *quota.Manager
+	mu    sync.Mutex
 }
 
 func NewDownloadManager() *DownloadManager {
 	return &DownloadManager{
 		Tasks:             make(map[string]*DownloadTaskInfo),
-		taskToDownloadMap: make(map[string]*got.Download),
+		jobs:              make(map[string]*downloadJob),
 		downloadToTaskMap: make(map[*got.Download]string),
+		retryPolicy:       DefaultRetryPolicy(),
+		retryTimers:       make(map[string]*time.Timer),
+		watchers:          make(map[*TaskWatcher]struct{}),
+		rateLimiter:       newRateLimiter(0),
+	}
+}
+
+// SetMaxConcurrent 设置同时进行的下载数上限，超出的任务以 queued 状态排队
+func (dm *DownloadManager) SetMaxConcurrent(n int) {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	dm.maxConcurrent = n
+	dm.scheduleLocked()
+}
+
+// SetRetryPolicy 设置全局重试策略，任务自己的策略中未设置的字段使用该策略
+func (dm *DownloadManager) SetRetryPolicy(policy RetryPolicy) error {
+	if err := policy.validate(); err != nil {
+		return err
+	}
+
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	dm.retryPolicy = policy
+	return nil
+}
+
+// SetQuota 设置下载文件时检查的配额
+func (dm *DownloadManager) SetQuota(quotas *quota.Manager) {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	dm.quota = quotas
+}
+
+// SetRateLimit 设置所有任务合计的下载限速（字节/秒），0 表示不限速，对正在进行的下载立即生效
+func (dm *DownloadManager) SetRateLimit(rate int64) error {
+	if rate < 0 {
+		return fmt.Errorf("%w: rate limit must not be negative", ErrBadRequest)
+	}
+	dm.rateLimiter.setLimit(rate)
+
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	for taskId, task := range dm.Tasks {
+		if task.EndAt == nil {
+			task.Status.RateLimit = dm.rateLimitOf(task)
+			dm.notifyLocked(taskId)
+		}
+	}
+	return nil
+}
+
+// rateLimitOf 返回任务实际的限速，即任务限速和全局限速中较小的非零值
+func (dm *DownloadManager) rateLimitOf(task *DownloadTaskInfo) int64 {
+	global := dm.rateLimiter.limit()
+	if task.RateLimit > 0 && (global <= 0 || task.RateLimit < global) {
+		return task.RateLimit
+	}
+	return global
+}
+
+// Restore 从 store 中加载历史任务，未结束的任务标记为 interrupted，之后的状态变化都会写回 store
+func (dm *DownloadManager) Restore(store *TaskStore) error {
+	tasks, err := store.Load()
+	if err != nil {
+		return err
+	}
+
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	for taskId, task := range tasks {
+		if task.EndAt == nil && task.Status.Status != "paused" {
+			task.Status.Status = "interrupted"
+		}
+		task.Status.Speed = ""
+		// 旧版本保存的任务 URL 中可能带有凭据
+		task.Url, task.Remote = task.Remote.withURLCredentials(task.Url)
+		dm.Tasks[taskId] = task
+	}
+	dm.store = store
+	dm.persistLocked()
+	return nil
+}
+
+// persistLocked 将当前任务快照写入 store，调用方需持有 dm.mu
+func (dm *DownloadManager) persistLocked() {
+	if dm.store == nil {
+		return
+	}
+
+	tasks := make([]*DownloadTaskInfo, 0, len(dm.Tasks))
+	for _, task := range dm.Tasks {
+		tasks = append(tasks, task)
+	}
+	if err := dm.store.Save(tasks); err != nil {
+		log.Printf("failed to save download tasks: %v", err)
 	}
 }
 
+// GetTaskStatus 返回任务的副本，任务不存在时返回 nil
 func (dm *DownloadManager) GetTaskStatus(taskId string) *DownloadTaskInfo {
-	return dm.Tasks[taskId]
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	task := dm.Tasks[taskId]
+	if task == nil {
+		return nil
+	}
+	return task.snapshot()
 }
 
+// List 返回符合条件的任务副本
 func (dm *DownloadManager) List(taskIds []string, status string) []*DownloadTaskInfo {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
 	tasks := make([]*DownloadTaskInfo, 0, len(dm.Tasks))
 	if len(taskIds) == 0 {
 		taskIds = make([]string, 0, len(dm.Tasks))
@@ -114,92 +329,635 @@ func (dm *DownloadManager) List(taskIds []string, status string) []*DownloadTask
 	for _, taskId := range taskIds {
 		task := dm.Tasks[taskId]
 		if task != nil && (status == "" || task.Status.Status == status) {
-			tasks = append(tasks, task)
+			tasks = append(tasks, task.snapshot())
 		}
 	}
 	return tasks
 }
 
 func (dm *DownloadManager) ProgressFunc(d *got.Download) {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
 	taskId := dm.downloadToTaskMap[d]
-	downloaded := d.Size()
+	task := dm.Tasks[taskId]
+	job := dm.jobs[taskId]
+	if task == nil || job == nil || job.download != d || d.Context().Err() != nil {
+		return
+	}
+	downloaded := job.downloaded()
 
-	if downloaded > dm.Tasks[taskId].Status.Downloaded {
-		dm.Tasks[taskId].Status.Status = "downloading"
+	if status := task.Status.Status; status != "pending" && status != "downloading" {
+		return
+	}
+	if downloaded > task.Status.Downloaded {
+		task.Status.Status = "downloading"
 	}
-	dm.Tasks[taskId].Status.Downloaded = downloaded
-	dm.Tasks[taskId].Status.TotalSize = d.TotalSize()
-	dm.Tasks[taskId].Status.Speed = humanReadableSize(int64(d.AvgSpeed())) + "/s"
+	task.Status.Downloaded = downloaded
+	task.Status.TotalSize = job.total
+	task.Status.Speed = humanReadableSize(int64(job.speed())) + "/s"
+	dm.notifyLocked(taskId)
 }
 
 func (dm *DownloadManager) CompleteTask(taskId string) {
-	download := dm.taskToDownloadMap[taskId]
-	download.StopProgress = true
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	dm.completeTaskLocked(taskId)
+}
+
+func (dm *DownloadManager) completeTaskLocked(taskId string) {
+	if job := dm.jobs[taskId]; job != nil {
+		job.cancel()
+		dm.Tasks[taskId].Status.Downloaded = job.total
+		dm.Tasks[taskId].Status.TotalSize = job.total
+	}
 	dm.Tasks[taskId].Status.Status = "finished"
+	dm.Tasks[taskId].Status.Speed = ""
 	var timeNow = time.Now()
 	dm.Tasks[taskId].EndAt = &timeNow
+	dm.persistLocked()
+	dm.notifyLocked(taskId)
 }
 
 func (dm *DownloadManager) FailTask(taskId string, errMsg string) {
-	download := dm.taskToDownloadMap[taskId]
-	download.StopProgress = true
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	dm.failTaskLocked(taskId, errMsg)
+}
+
+func (dm *DownloadManager) failTaskLocked(taskId string, errMsg string) {
+	if job := dm.jobs[taskId]; job != nil {
+		job.cancel()
+	}
 	dm.Tasks[taskId].Status.Status = "failed"
+	dm.Tasks[taskId].Status.Speed = ""
 	dm.Tasks[taskId].Status.ErrMsg = errMsg
 	var timeNow = time.Now()
 	dm.Tasks[taskId].EndAt = &timeNow
+	dm.persistLocked()
+	dm.notifyLocked(taskId)
 }
 
-func (dm *DownloadManager) AddTask(url, dir string) (string, error) {
-	download := &got.Download{
-		URL: url,
-		Dir: dir,
+// retryOrFailLocked 在策略允许时等待一段时间后自动重试，否则将任务标记为失败
+func (dm *DownloadManager) retryOrFailLocked(taskId string, err error, statusCode int) {
+	task := dm.Tasks[taskId]
+	policy := dm.retryPolicy.merge(task.Retry)
+	if task.Retries+1 >= policy.MaxAttempts || !policy.retryable(err, statusCode) {
+		dm.failTaskLocked(taskId, err.Error())
+		return
+	}
+
+	delay := policy.backoff(task.Retries)
+	retryAt := time.Now().Add(delay)
+	task.Retries++
+	task.NextRetryAt = &retryAt
+	task.Status.Status = "retrying"
+	task.Status.Speed = ""
+	task.Status.ErrMsg = err.Error()
+
+	var timer *time.Timer
+	timer = time.AfterFunc(delay, func() {
+		dm.mu.Lock()
+		defer dm.mu.Unlock()
+		// 等待期间被暂停或取消
+		if dm.retryTimers[taskId] != timer {
+			return
+		}
+		delete(dm.retryTimers, taskId)
+		dm.enqueueLocked(taskId, nil)
+	})
+	dm.retryTimers[taskId] = timer
+	dm.persistLocked()
+	dm.notifyLocked(taskId)
+}
+
+// stopRetryLocked 取消等待中的自动重试
+func (dm *DownloadManager) stopRetryLocked(taskId string) {
+	if timer := dm.retryTimers[taskId]; timer != nil {
+		timer.Stop()
+		delete(dm.retryTimers, taskId)
+	}
+	dm.Tasks[taskId].NextRetryAt = nil
+}
+
+// DownloadOptions 是添加下载任务时的可选参数
+type DownloadOptions struct {
+	// Name 为保存的文件名，可以包含子目录，为空时由 URL 或响应头决定
+	Name string
+	// OnConflict 为目标文件已存在时的处理方式：overwrite（默认）、rename、fail
+	OnConflict string
+	// Priority 越大越先开始，相同优先级按添加顺序
+	Priority int
+	// Checksums 为下载完成后需要校验的摘要，键为 md5、sha1 或 sha256
+	Checksums map[string]string
+	// DeleteOnMismatch 为 true 时校验失败会删除文件
+	DeleteOnMismatch bool
+	// Remote 为访问下载源时使用的请求参数
+	Remote *RemoteOptions
+	// Retry 为任务自己的重试策略，为空时使用全局策略
+	Retry *RetryPolicy
+	// RateLimit 为任务自己的限速（字节/秒），0 表示只受全局限速约束
+	RateLimit int64
+	// Owner 为添加任务的用户，下载的文件计入该用户的配额
+	Owner string
+	// Root 为添加任务的用户的根目录，文件只能保存在其中，为空时为服务的根目录
+	Root string
+}
+
+// AddTask 添加下载任务到队列，目标文件按 opts.OnConflict 处理重名
+func (dm *DownloadManager) AddTask(rawUrl, dir string, opts DownloadOptions) (string, error) {
+	if u, err := url.Parse(rawUrl); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", fmt.Errorf("%w: invalid download url: %s", ErrBadRequest, redactURL(rawUrl))
+	}
+	checksums, err := normalizeChecksums(opts.Checksums)
+	if err != nil {
+		return "", err
 	}
-	if err := download.Init(); err != nil {
+	rawUrl, remote := opts.Remote.withURLCredentials(rawUrl)
+	if remote.isEmpty() {
+		remote = nil
+	} else if err := remote.validate(); err != nil {
 		return "", err
 	}
+	if err := opts.Retry.validate(); err != nil {
+		return "", err
+	}
+	if opts.RateLimit < 0 {
+		return "", fmt.Errorf("%w: rate limit must not be negative", ErrBadRequest)
+	}
 
-	taskId := uuid.New().String()
-	path := download.Path()
-	relPath, err := filepath.Rel(rootDir, path)
-	if err == nil {
-		path = relPath
+	// 只有覆盖时才允许由响应头决定文件名，其它策略需要在下载前确定目标路径
+	name := opts.Name
+	if name == "" && opts.OnConflict != "" && opts.OnConflict != "overwrite" {
+		name = got.GetFilename(rawUrl)
+	}
+
+	root := opts.Root
+	if root == "" {
+		root = rootDir
+	}
+	if !isSubDir(root, dir) {
+		return "", fmt.Errorf("%w: invalid directory: %s", ErrBadRequest, relToRoot(dir))
+	}
+
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	path := filepath.Join(dir, got.GetFilename(rawUrl))
+	if name != "" {
+		path = filepath.Join(dir, name)
+		if !isSubDir(root, path) || path == filepath.Clean(dir) {
+			return "", fmt.Errorf("%w: invalid file name: %s", ErrBadRequest, name)
+		}
+
+		path, err = resolveConflict(path, opts.OnConflict, dm.pathTakenLocked)
+		if err != nil {
+			return "", err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return "", err
+		}
 	}
+
+	taskId := uuid.New().String()
 	timeNow := time.Now()
 	dm.Tasks[taskId] = &DownloadTaskInfo{
 		TaskId:   taskId,
-		Url:      url,
-		Filepath: path,
+		Url:      rawUrl,
+		Filepath: relToRoot(path),
 		Filename: filepath.Base(path),
+		Priority: opts.Priority,
 		Status: &DownloadStatus{
-			Status: "pending",
+			Status: "queued",
 		},
-		StartedAt: &timeNow,
+		StartedAt:         &timeNow,
+		ExpectedChecksums: checksums,
+		DeleteOnMismatch:  opts.DeleteOnMismatch,
+		Remote:            remote,
+		Retry:             opts.Retry,
+		RateLimit:         opts.RateLimit,
+		Owner:             opts.Owner,
+	}
+
+	job := newDownloadJob(dm.Tasks[taskId], dir, "")
+	if name != "" {
+		job = newDownloadJob(dm.Tasks[taskId], filepath.Dir(path), filepath.Base(path))
+	}
+	dm.enqueueLocked(taskId, job)
+	return taskId, nil
+}
+
+// pathTakenLocked 判断路径是否已存在，或已被未结束的任务占用
+func (dm *DownloadManager) pathTakenLocked(path string) bool {
+	if ok, _ := exists(path); ok {
+		return true
+	}
+	for _, task := range dm.Tasks {
+		if task.EndAt == nil && dm.taskPath(task) == path {
+			return true
+		}
+	}
+	return false
+}
+
+// PauseTask 暂停排队中或正在进行的任务，已下载的部分会保留用于续传
+func (dm *DownloadManager) PauseTask(taskId string) error {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	task := dm.Tasks[taskId]
+	if task == nil {
+		return fmt.Errorf("%w: %s", ErrTaskNotFound, taskId)
+	}
+	switch task.Status.Status {
+	case "queued":
+		dm.dequeueLocked(taskId)
+	case "pending", "downloading":
+		dm.jobs[taskId].cancel()
+	case "retrying":
+		dm.stopRetryLocked(taskId)
+	default:
+		return fmt.Errorf("task %s is %s, only queued, pending, downloading or retrying tasks can be paused", taskId, task.Status.Status)
+	}
+
+	task.Status.Status = "paused"
+	task.Status.Speed = ""
+	dm.persistLocked()
+	dm.notifyLocked(taskId)
+	return nil
+}
+
+// ResumeTask 将暂停或被中断的任务重新排队，开始时支持 Range 的文件从未完成的位置续传，否则重新下载
+func (dm *DownloadManager) ResumeTask(taskId string) error {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	task := dm.Tasks[taskId]
+	if task == nil {
+		return fmt.Errorf("%w: %s", ErrTaskNotFound, taskId)
+	}
+	if status := task.Status.Status; status != "paused" && status != "interrupted" {
+		return fmt.Errorf("task %s is %s, only paused or interrupted tasks can be resumed", taskId, status)
+	}
+
+	dm.enqueueLocked(taskId, nil)
+	return nil
+}
+
+// RetryTask 重新开始失败的任务并清零自动重试次数，支持 Range 的文件从未完成的位置续传
+func (dm *DownloadManager) RetryTask(taskId string) error {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	task := dm.Tasks[taskId]
+	if task == nil {
+		return fmt.Errorf("%w: %s", ErrTaskNotFound, taskId)
+	}
+	if task.Status.Status != "failed" {
+		return fmt.Errorf("task %s is %s, only failed tasks can be retried", taskId, task.Status.Status)
+	}
+
+	task.Retries = 0
+	task.EndAt = nil
+	dm.enqueueLocked(taskId, nil)
+	return nil
+}
+
+// CancelTask 取消未结束的任务并删除已下载的部分文件
+func (dm *DownloadManager) CancelTask(taskId string) error {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	task := dm.Tasks[taskId]
+	if task == nil {
+		return fmt.Errorf("%w: %s", ErrTaskNotFound, taskId)
+	}
+	if task.EndAt != nil {
+		return fmt.Errorf("task %s is already %s", taskId, task.Status.Status)
+	}
+
+	dm.dequeueLocked(taskId)
+	dm.stopRetryLocked(taskId)
+	if job := dm.jobs[taskId]; job != nil {
+		job.cancel()
+	}
+	if err := os.RemoveAll(taskTempDir(filepath.Dir(dm.taskPath(task)), taskId)); err != nil {
+		log.Printf("failed to remove partial file of task %s: %v", taskId, err)
 	}
 
-	dm.downloadToTaskMap[download] = taskId
-	dm.taskToDownloadMap[taskId] = download
+	task.Status.Status = "canceled"
+	task.Status.Speed = ""
+	var timeNow = time.Now()
+	task.EndAt = &timeNow
+	dm.persistLocked()
+	dm.notifyLocked(taskId)
+	return nil
+}
+
+// ResumeInterrupted 恢复所有被中断的任务
+func (dm *DownloadManager) ResumeInterrupted() {
+	for _, task := range dm.List(nil, "interrupted") {
+		if err := dm.ResumeTask(task.TaskId); err != nil {
+			log.Printf("failed to resume task %s: %v", task.TaskId, err)
+		}
+	}
+}
+
+// TaskTempDirs 返回所有任务的临时目录，暂停或中断的任务已下载的部分保存在其中
+func (dm *DownloadManager) TaskTempDirs() map[string]bool {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	dirs := make(map[string]bool, len(dm.Tasks))
+	for taskId, task := range dm.Tasks {
+		dirs[taskTempDir(filepath.Dir(dm.taskPath(task)), taskId)] = true
+	}
+	return dirs
+}
+
+// taskPath 返回任务目标文件的完整路径
+func (dm *DownloadManager) taskPath(task *DownloadTaskInfo) string {
+	if filepath.IsAbs(task.Filepath) {
+		return task.Filepath
+	}
+	return filepath.Join(rootDir, task.Filepath)
+}
+
+// enqueueLocked 将任务放入等待队列，job 为 nil 时在开始时根据上一次运行决定续传或重新下载
+func (dm *DownloadManager) enqueueLocked(taskId string, job *downloadJob) {
+	dm.Tasks[taskId].Status.Status = "queued"
+	dm.Tasks[taskId].Status.Speed = ""
+	dm.Tasks[taskId].NextRetryAt = nil
+	dm.queue = append(dm.queue, &queuedJob{
+		taskId: taskId,
+		job:    job,
+	})
+	dm.persistLocked()
+	dm.notifyLocked(taskId)
+	dm.scheduleLocked()
+}
+
+// dequeueLocked 将任务从等待队列中移除
+func (dm *DownloadManager) dequeueLocked(taskId string) {
+	for i, item := range dm.queue {
+		if item.taskId == taskId {
+			if item.job != nil {
+				item.job.cancel()
+			}
+			dm.queue = append(dm.queue[:i], dm.queue[i+1:]...)
+			return
+		}
+	}
+}
+
+// scheduleLocked 在并发数允许的范围内按优先级从队列中取出任务开始下载
+func (dm *DownloadManager) scheduleLocked() {
+	for len(dm.queue) > 0 && (dm.maxConcurrent <= 0 || dm.active < dm.maxConcurrent) {
+		next := 0
+		for i, item := range dm.queue {
+			if dm.Tasks[item.taskId].Priority > dm.Tasks[dm.queue[next].taskId].Priority {
+				next = i
+			}
+		}
+		item := dm.queue[next]
+		dm.queue = append(dm.queue[:next], dm.queue[next+1:]...)
+
+		job := item.job
+		if job == nil {
+			task := dm.Tasks[item.taskId]
+			if job = resumeJob(task, dm.jobs[item.taskId]); job == nil {
+				filePath := dm.taskPath(task)
+				job = newDownloadJob(task, filepath.Dir(filePath), filepath.Base(filePath))
+			}
+		}
+		dm.startJobLocked(item.taskId, job)
+	}
+}
+
+// startJobLocked 登记并启动一次下载运行，调用方需持有 dm.mu
+func (dm *DownloadManager) startJobLocked(taskId string, job *downloadJob) {
+	if old := dm.jobs[taskId]; old != nil {
+		old.cancel()
+		delete(dm.downloadToTaskMap, old.download)
+	}
+	dm.downloadToTaskMap[job.download] = taskId
+	dm.jobs[taskId] = job
+	dm.active++
+	job.startedAt = time.Now()
+
+	task := dm.Tasks[taskId]
+	job.download.Client = throttle(job.download.Client, dm.rateLimiter, newRateLimiter(task.RateLimit))
+	task.Status = &DownloadStatus{
+		Status:     "pending",
+		TotalSize:  job.total,
+		Downloaded: job.base,
+		RateLimit:  dm.rateLimitOf(task),
+	}
+	task.AttemptCount++
+	task.Attempts = append(task.Attempts, DownloadAttempt{StartedAt: job.startedAt})
+	if len(task.Attempts) > maxAttemptHistory {
+		task.Attempts = task.Attempts[len(task.Attempts)-maxAttemptHistory:]
+	}
+	dm.persistLocked()
+	dm.notifyLocked(taskId)
 
 	go func() {
-		if err := download.Start(); err != nil {
-			dm.FailTask(taskId, err.Error())
+		var err error
+		if !job.ready {
+			if err = os.MkdirAll(job.download.Dir, 0755); err == nil {
+				err = job.download.Init()
+			}
+			if err == nil {
+				dm.mu.Lock()
+				job.prepare()
+				task.Filepath = relToRoot(job.target)
+				task.Filename = filepath.Base(job.target)
+				task.Status.TotalSize = job.total
+				dm.notifyLocked(taskId)
+				dm.mu.Unlock()
+				err = dm.reserveQuota(task, job)
+			}
+		}
+		if err == nil {
+			err = job.fetch()
+		}
+		if err == nil && len(task.ExpectedChecksums) > 0 {
+			err = dm.verifyJob(task, job)
+		}
+		// 校验不一致但不删除的文件同样移动到目标位置，便于检查
+		if err == nil || (errors.Is(err, ErrChecksumMismatch) && !task.DeleteOnMismatch && job.download.Context().Err() == nil) {
+			if commitErr := job.commit(); commitErr != nil && err == nil {
+				err = commitErr
+			}
+		}
+
+		dm.mu.Lock()
+		defer dm.mu.Unlock()
+		dm.active--
+		defer dm.scheduleLocked()
+
+		// 已被新的运行替换时不再改变任务状态
+		if dm.jobs[taskId] != job {
+			return
+		}
+		statusCode := int(atomic.LoadInt32(&job.status))
+		// 被暂停或取消时只记录运行结束
+		if job.download.Context().Err() != nil {
+			endAttempt(task, nil, 0)
+			return
+		}
+		endAttempt(task, err, statusCode)
+		if err != nil {
+			dm.retryOrFailLocked(taskId, err, statusCode)
 		} else {
-			dm.CompleteTask(taskId)
+			dm.completeTaskLocked(taskId)
 		}
 	}()
 
 	go func() {
-		download.RunProgress(dm.ProgressFunc)
+		job.download.RunProgress(dm.ProgressFunc)
 	}()
+}
 
-	return taskId, nil
+// reserveQuota 在知道文件大小后检查任务所有者的配额。got 在 Init 时已经创建了文件（不支持 Range 时已经下载完），
+// 因此按新文件计算，超过配额时删除文件
+func (dm *DownloadManager) reserveQuota(task *DownloadTaskInfo, job *downloadJob) error {
+	dm.mu.Lock()
+	quotas := dm.quota
+	dm.mu.Unlock()
+
+	size := int64(job.total)
+	if !job.rangeable {
+		if stat, err := os.Stat(job.path); err == nil {
+			size = stat.Size()
+		}
+	}
+	err := quotas.Reserve(task.Owner, job.path, size, true)
+	if err != nil {
+		if rmErr := os.Remove(job.path); rmErr != nil && !os.IsNotExist(rmErr) {
+			log.Printf("failed to remove file of task %s: %v", task.TaskId, rmErr)
+		}
+	}
+	return err
+}
+
+// verifyJob 校验下载完成的文件，不一致时按设置删除文件
+func (dm *DownloadManager) verifyJob(task *DownloadTaskInfo, job *downloadJob) error {
+	dm.mu.Lock()
+	if job.download.Context().Err() == nil {
+		task.Status.Status = "verifying"
+		task.Status.Speed = ""
+		dm.notifyLocked(task.TaskId)
+	}
+	dm.mu.Unlock()
+
+	checksums, err := verifyChecksums(job.path, task.ExpectedChecksums)
+
+	dm.mu.Lock()
+	task.Checksums = checksums
+	dm.mu.Unlock()
+
+	if err != nil && task.DeleteOnMismatch && checksums != nil {
+		if rmErr := os.Remove(job.path); rmErr != nil && !os.IsNotExist(rmErr) {
+			log.Printf("failed to remove file of task %s: %v", task.TaskId, rmErr)
+		}
+	}
+	return err
+}
+
+// ClearEndedTasks 删除结束时间早于 olderThan 之前的任务记录，status 不为空时只删除该状态的任务，返回删除的数量
+func (dm *DownloadManager) ClearEndedTasks(olderThan time.Duration, status string) (int, error) {
+	return dm.ClearEndedTasksWhere(nil, olderThan, status)
 }
 
-func (dm *DownloadManager) ClearEndedTasks(days int) {
+// ClearEndedTasksWhere 与 ClearEndedTasks 相同，但只删除 match 返回 true 的任务，match 为 nil 时不限制
+func (dm *DownloadManager) ClearEndedTasksWhere(match func(task *DownloadTaskInfo) bool, olderThan time.Duration, status string) (int, error) {
+	switch status {
+	case "", "finished", "failed", "canceled":
+	default:
+		return 0, fmt.Errorf("%w: only finished, failed or canceled tasks can be cleared", ErrBadRequest)
+	}
+	if olderThan < 0 {
+		return 0, fmt.Errorf("%w: olderThan must not be negative", ErrBadRequest)
+	}
+
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	removed := 0
 	for taskId, task := range dm.Tasks {
-		if task.EndAt != nil && time.Since(*task.EndAt).Hours() > float64(days*24) {
-			delete(dm.Tasks, taskId)
+		if task.EndAt == nil || time.Since(*task.EndAt) < olderThan {
+			continue
 		}
+		if status != "" && task.Status.Status != status {
+			continue
+		}
+		if match != nil && !match(task) {
+			continue
+		}
+		delete(dm.Tasks, taskId)
+		if job := dm.jobs[taskId]; job != nil {
+			delete(dm.downloadToTaskMap, job.download)
+			delete(dm.jobs, taskId)
+		}
+		// 失败的任务保留了用于重试的部分文件
+		os.RemoveAll(taskTempDir(filepath.Dir(dm.taskPath(task)), taskId))
+		removed++
 	}
+	if removed > 0 {
+		dm.persistLocked()
+	}
+	return removed, nil
+}
+
+// StartJanitor 立即并在之后每隔 interval 删除结束超过 retention 的任务记录，返回停止函数
+func (dm *DownloadManager) StartJanitor(retention, interval time.Duration) (stop func()) {
+	sweep := func() {
+		if removed, err := dm.ClearEndedTasks(retention, ""); err != nil {
+			log.Printf("failed to clear ended tasks: %v", err)
+		} else if removed > 0 {
+			log.Printf("cleared %d ended download tasks", removed)
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		sweep()
+		for {
+			select {
+			case <-ticker.C:
+				sweep()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			ticker.Stop()
+			close(done)
+		})
+	}
+}
+
+// snapshot 返回任务的副本，避免在锁外读到正在被修改的状态
+func (t *DownloadTaskInfo) snapshot() *DownloadTaskInfo {
+	task := *t
+	status := *t.Status
+	task.Status = &status
+	task.Attempts = append([]DownloadAttempt(nil), t.Attempts...)
+	return &task
+}
+
+// relToRoot 将路径转换为相对 rootDir 的路径，失败时原样返回
+func relToRoot(path string) string {
+	if relPath, err := filepath.Rel(rootDir, path); err == nil {
+		return relPath
+	}
+	return path
 }
 
 func humanReadableSize(size int64) string {
@@ -227,6 +985,10 @@ func genIndexHtml(rootDir string, uri string) string {
 		html += "<script>onHasParentDirectory();</script>"
 	}
 
+	items = slices.DeleteFunc(items, func(item os.DirEntry) bool {
+		return isTempFile(item.Name())
+	})
+
 	for _, item := range items {
 		if item.IsDir() {
 			info, _ := item.Info()
@@ -264,6 +1026,9 @@ func genJson(rootDir string, uri string) []map[string]interface{} {
 	files := make([]map[string]interface{}, 0)
 
 	for _, item := range items {
+		if isTempFile(item.Name()) {
+			continue
+		}
 		info, _ := item.Info()
 		file := make(map[string]interface{})
 		file["name"] = item.Name()
@@ -279,7 +1044,29 @@ func genJson(rootDir string, uri string) []map[string]interface{} {
 	return files
 }
 
-func handleListTask(c *gin.Context) {
+// taskVisible 判断 root 目录的用户能否看到任务，root 为 rootDir 时可以看到所有任务
+func taskVisible(task *DownloadTaskInfo, root string) bool {
+	return root == rootDir || isSubDir(root, manager.taskPath(task))
+}
+
+// taskURI 返回任务目标文件相对 root 目录的请求路径，用于按 ACL 检查权限
+func taskURI(task *DownloadTaskInfo, root string) string {
+	rel, err := filepath.Rel(root, manager.taskPath(task))
+	if err != nil {
+		return "/" + filepath.ToSlash(task.Filepath)
+	}
+	return "/" + filepath.ToSlash(rel)
+}
+
+// taskAllowed 判断当前用户能否以 method 访问任务：任务对 root 目录的用户可见，且 ACL 允许访问其目标文件
+func taskAllowed(c *gin.Context, authConfig *auth.AuthConfig, root string, task *DownloadTaskInfo, method string) bool {
+	if !taskVisible(task, root) {
+		return false
+	}
+	return authConfig == nil || authConfig.Authorize(method, taskURI(task, root), auth.UserFromRequest(c.Request)).StatusCode() == 0
+}
+
+func handleListTask(c *gin.Context, authConfig *auth.AuthConfig, root string) {
 	req := &ListTaskRequest{}
 	err := c.BindJSON(req)
 	if err != nil {
@@ -292,6 +1079,9 @@ func handleListTask(c *gin.Context) {
 	for _, item := range req.OrItems {
 		tasks := manager.List(item.TaskIds, item.Status)
 		for _, task := range tasks {
+			if !taskAllowed(c, authConfig, root, task, http.MethodGet) {
+				continue
+			}
 			if _, ok := taskIdMap[task.TaskId]; !ok {
 				ret = append(ret, task)
 				taskIdMap[task.TaskId] = true
@@ -304,6 +1094,78 @@ func handleListTask(c *gin.Context) {
 	})
 }
 
+// handleWatchTasks 以 Server-Sent Events 推送任务状态，先推送当前状态，之后推送每次变化
+func handleWatchTasks(c *gin.Context, authConfig *auth.AuthConfig, root string) {
+	taskIds := c.QueryArray("taskId")
+	// 先订阅再读取当前状态，避免漏掉两者之间的变化
+	watcher := manager.Watch(taskIds)
+	defer watcher.Close()
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	for _, task := range manager.List(taskIds, "") {
+		if taskAllowed(c, authConfig, root, task, http.MethodGet) {
+			c.SSEvent("task", task)
+		}
+	}
+	c.Writer.Flush()
+
+	keepalive := time.NewTicker(taskEventKeepalive)
+	defer keepalive.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-watcher.C():
+			for _, task := range watcher.Drain() {
+				if taskAllowed(c, authConfig, root, task, http.MethodGet) {
+					c.SSEvent("task", task)
+				}
+			}
+		case <-keepalive.C:
+			c.SSEvent("ping", time.Now().Unix())
+		}
+		return true
+	})
+}
+
+// errorStatus 返回错误对应的 HTTP 状态码
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrTaskNotFound), errors.Is(err, ErrShareNotFound), errors.Is(err, ErrUploadNotFound):
+		return 404
+	case errors.Is(err, ErrFileExists), errors.Is(err, ErrUploadConflict):
+		return 409
+	case errors.Is(err, ErrBadRequest):
+		return 400
+	case errors.Is(err, quota.ErrExceeded):
+		return http.StatusInsufficientStorage
+	default:
+		return 500
+	}
+}
+
+// handleTaskOperation 对指定任务执行暂停、继续或取消操作，当前用户看不到的任务视为不存在，
+// 按 ACL 不能写入任务的目标文件时拒绝操作
+func handleTaskOperation(c *gin.Context, authConfig *auth.AuthConfig, root string, taskId string, op func(taskId string) error) {
+	if task := manager.GetTaskStatus(taskId); task != nil {
+		if !taskAllowed(c, authConfig, root, task, http.MethodGet) {
+			c.String(errorStatus(ErrTaskNotFound), ErrTaskNotFound.Error())
+			return
+		}
+		if !authorizeWrite(c, authConfig, auth.ScopeDownloadTasks, taskURI(task, root)) {
+			return
+		}
+	}
+	if err := op(taskId); err != nil {
+		c.String(errorStatus(err), err.Error())
+		return
+	}
+	c.JSON(200, DownloadResponse{
+		TaskId: taskId,
+	})
+}
+
 func exists(path string) (bool, error) {
 	_, err := os.Stat(path)
 	if err == nil {
@@ -315,6 +1177,93 @@ func exists(path string) (bool, error) {
 	return false, err
 }
 
+// resolveConflict 按策略处理目标路径已被占用的情况，rename 时追加数字后缀，如 file_1.txt
+func resolveConflict(target, policy string, taken func(path string) bool) (string, error) {
+	switch policy {
+	case "", "overwrite":
+		return target, nil
+	case "fail":
+		if taken(target) {
+			return "", fmt.Errorf("%w: %s", ErrFileExists, relToRoot(target))
+		}
+		return target, nil
+	case "rename":
+		ext := filepath.Ext(target)
+		base := strings.TrimSuffix(target, ext)
+		candidate := target
+		for i := 1; taken(candidate); i++ {
+			candidate = fmt.Sprintf("%s_%d%s", base, i, ext)
+		}
+		return candidate, nil
+	default:
+		return "", fmt.Errorf("%w: unknown conflict policy: %s", ErrBadRequest, policy)
+	}
+}
+
+// formUpload 表单上传中的一个文件和它的保存位置
+type formUpload struct {
+	file *multipart.FileHeader
+	// 相对请求目录的路径，上传文件夹时包含子目录
+	name string
+	// 文件系统中的完整路径，为空表示按 skip 策略跳过
+	target string
+}
+
+// formUploads 确定表单上传的文件的保存位置。relativePath 字段按顺序给出每个文件的相对路径（上传文件夹时的
+// webkitRelativePath），其中的子目录在 dir 中创建；onConflict 字段为目标文件已存在时的处理方式：
+// overwrite（默认）、skip、rename、fail，fail 时任一文件已存在则不保存任何文件
+func formUploads(form *multipart.Form, dir string) ([]formUpload, error) {
+	files := form.File["files"]
+	paths := form.Value["relativePath"]
+	if len(paths) > 0 && len(paths) != len(files) {
+		return nil, fmt.Errorf("%w: got %d relative paths for %d files", ErrBadRequest, len(paths), len(files))
+	}
+	policy := ""
+	if values := form.Value["onConflict"]; len(values) > 0 {
+		policy = values[0]
+	}
+
+	// 同一请求中的文件也不能互相覆盖
+	claimed := make(map[string]bool)
+	taken := func(path string) bool {
+		ok, _ := exists(path)
+		return ok || claimed[path]
+	}
+	uploads := make([]formUpload, 0, len(files))
+	for i, file := range files {
+		name := file.Filename
+		if len(paths) > 0 && paths[i] != "" {
+			name = paths[i]
+		}
+		name = path.Clean("/" + strings.ReplaceAll(name, "\\", "/"))
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if !isSubDir(dir, target) || target == filepath.Clean(dir) || slices.ContainsFunc(strings.Split(name, "/"), isTempFile) {
+			return nil, fmt.Errorf("%w: invalid file name: %s", ErrBadRequest, name)
+		}
+
+		var err error
+		if policy == "skip" {
+			if taken(target) {
+				target = ""
+			}
+		} else if target, err = resolveConflict(target, policy, taken); err != nil {
+			return nil, err
+		}
+		if target != "" {
+			if stat, err := os.Stat(target); err == nil && stat.IsDir() {
+				return nil, fmt.Errorf("%w: %s is a directory", ErrFileExists, relToRoot(target))
+			}
+			claimed[target] = true
+			rel, _ := filepath.Rel(dir, target)
+			name = filepath.ToSlash(rel)
+		} else {
+			name = strings.TrimPrefix(name, "/")
+		}
+		uploads = append(uploads, formUpload{file: file, name: name, target: target})
+	}
+	return uploads, nil
+}
+
 func isSubDir(parent string, child string) bool {
 	parent, err := filepath.Abs(parent)
 	if err != nil {
@@ -324,7 +1273,82 @@ func isSubDir(parent string, child string) bool {
 	if err != nil {
 		return false
 	}
-	return child == parent || strings.HasPrefix(child, parent+string(os.PathSeparator))
+	return child == parent || strings.HasPrefix(child, parent+string(os.PathSeparator))
+}
+
+// authorizeWrite 检查当前用户能否以 scope 权限范围写入 uri，无权限时返回 401 或 403 并返回 false
+func authorizeWrite(c *gin.Context, authConfig *auth.AuthConfig, scope string, uri string) bool {
+	return authorizeTarget(c, authConfig, http.MethodPost, scope, uri)
+}
+
+// authorizeRead 检查当前用户能否读取 uri，无权限时返回 401 或 403 并返回 false
+func authorizeRead(c *gin.Context, authConfig *auth.AuthConfig, uri string) bool {
+	return authorizeTarget(c, authConfig, http.MethodGet, auth.ScopeRead, uri)
+}
+
+// authorizeTarget 检查当前用户能否以 method 访问 uri，无权限时返回 401 或 403 并返回 false
+func authorizeTarget(c *gin.Context, authConfig *auth.AuthConfig, method, scope, uri string) bool {
+	if !requireScope(c, authConfig, scope) {
+		return false
+	}
+	if authConfig == nil {
+		return true
+	}
+	status := authConfig.Authorize(method, uri, auth.UserFromRequest(c.Request)).StatusCode()
+	if status != 0 {
+		authConfig.Reject(c.Writer, c.Request, status)
+		c.Abort()
+		return false
+	}
+	return true
+}
+
+// requireScope 检查请求的 API 令牌是否有 scope 权限，没有时返回 403 并返回 false
+func requireScope(c *gin.Context, authConfig *auth.AuthConfig, scope string) bool {
+	if authConfig == nil || auth.HasScope(c.Request, scope) {
+		return true
+	}
+	c.String(403, "403 forbidden: token requires %s scope", scope)
+	c.Abort()
+	return false
+}
+
+// userRoot 返回用户可见的根目录：用户在用户文件中配置了主目录，或启用了 homeDirs 时为其主目录
+// （相对路径基于 dir，不存在时自动创建），匿名用户和其他情况为 dir
+func userRoot(authConfig *auth.AuthConfig, dir string, homeDirs bool, username string) (string, error) {
+	if authConfig == nil || username == "" {
+		return dir, nil
+	}
+	home := authConfig.HomeDir(username)
+	if home == "" {
+		if !homeDirs {
+			return dir, nil
+		}
+		if username == "." || username == ".." || strings.ContainsAny(username, `/\`) {
+			return "", fmt.Errorf("username %q cannot be used as a home directory", username)
+		}
+		home = username
+	}
+	if !filepath.IsAbs(home) {
+		home = filepath.Join(dir, home)
+	}
+	if err := os.MkdirAll(home, 0755); err != nil {
+		return "", err
+	}
+	return home, nil
+}
+
+// webdavDestination 返回 WebDAV 请求 Destination 头中去掉 /$.dav$ 前缀后的路径
+func webdavDestination(r *http.Request) string {
+	destination := r.Header.Get("Destination")
+	if u, err := url.Parse(destination); err == nil {
+		destination = u.Path
+	}
+	destination = strings.TrimPrefix(destination, "/$.dav$")
+	if destination == "" {
+		destination = "/"
+	}
+	return destination
 }
 
 func start_server(c *cli.Context) error {
@@ -333,30 +1357,236 @@ func start_server(c *cli.Context) error {
 	enableWebDAV := c.Bool("webdav")
 	username := c.String("username")
 	password := c.String("password")
+	usersFile := c.String("users-file")
+	aclFile := c.String("acl-file")
+	tokensFile := c.String("tokens-file")
+	homeDirs := c.Bool("home-dirs")
+	quotaFile := c.String("quota-file")
+	auditLogFile := c.String("audit-log")
+	sessionTTL := c.Duration("session-ttl")
+	maxLoginFailures := c.Int("max-login-failures")
+	loginLockout := c.Duration("login-lockout")
+	admins := c.StringSlice("admin")
 	requireReadAuth := c.Bool("auth-read")
 	requireWriteAuth := c.Bool("auth-write")
+	stateDir := c.String("state-dir")
+	resumeTasks := c.Bool("resume-tasks")
+	maxDownloads := c.Int("max-downloads")
+	taskRetention := c.Duration("task-retention")
+	rateLimit := c.Int64("rate-limit")
+	tlsOptions := &TLSOptions{
+		CertFile:          c.String("tls-cert"),
+		KeyFile:           c.String("tls-key"),
+		SelfSigned:        c.Bool("tls-self-signed"),
+		ClientCAFile:      c.String("tls-client-ca"),
+		RequireClientCert: c.Bool("tls-require-client-cert"),
+	}
+	redirectPort := c.String("http-redirect-port")
 
 	rootDir = dir
+	manager.SetMaxConcurrent(maxDownloads)
+
+	// 自签名证书默认保存在状态目录中，重启后继续使用同一个证书
+	if tlsOptions.SelfSigned && tlsOptions.CertFile == "" && tlsOptions.KeyFile == "" {
+		if stateDir == "" {
+			return fmt.Errorf("--tls-self-signed requires --state-dir or --tls-cert and --tls-key to persist the certificate")
+		}
+		tlsOptions.CertFile = filepath.Join(stateDir, selfSignedCertFile)
+		tlsOptions.KeyFile = filepath.Join(stateDir, selfSignedKeyFile)
+	}
+	if !tlsOptions.Enabled() && (tlsOptions.ClientCAFile != "" || redirectPort != "") {
+		return fmt.Errorf("--tls-client-ca and --http-redirect-port require HTTPS (--tls-cert/--tls-key or --tls-self-signed)")
+	}
+	var tlsConfig *tls.Config
+	if tlsOptions.Enabled() {
+		var err error
+		if tlsConfig, err = tlsOptions.Config(); err != nil {
+			return err
+		}
+	}
+
+	retryPolicy := DefaultRetryPolicy()
+	retryPolicy.MaxAttempts = c.Int("retry-attempts")
+	retryPolicy.Backoff = Duration(c.Duration("retry-backoff"))
+	if err := manager.SetRetryPolicy(retryPolicy); err != nil {
+		return err
+	}
+	if err := manager.SetRateLimit(rateLimit); err != nil {
+		return err
+	}
+	if rateLimit > 0 {
+		fmt.Printf("Download rate limited to %s/s\n", humanReadableSize(rateLimit))
+	}
+
+	// 加载持久化的下载任务
+	if stateDir != "" {
+		store, err := NewTaskStore(stateDir)
+		if err != nil {
+			return err
+		}
+		if err := manager.Restore(store); err != nil {
+			return err
+		}
+		fmt.Printf("Download tasks persisted in %s\n", stateDir)
+	}
+
+	// 之前的运行中断后残留的临时文件，保留的任务的临时目录用于继续下载
+	if removed := cleanTempFiles(dir, manager.TaskTempDirs()); removed > 0 {
+		fmt.Printf("Removed %d temporary files of interrupted uploads and downloads\n", removed)
+	}
+	if stateDir != "" && resumeTasks {
+		go manager.ResumeInterrupted()
+	}
+
+	// 登录和分享密码共用失败次数限制
+	var loginLimiter *auth.LoginLimiter
+	if maxLoginFailures > 0 {
+		loginLimiter = auth.NewLoginLimiter(maxLoginFailures, loginLockout)
+	}
+
+	shareManager, err := NewShareManager(stateDir)
+	if err != nil {
+		return err
+	}
+	shareManager.SetLoginLimiter(loginLimiter)
+	uploadManager, err := NewUploadManager(stateDir, c.Duration("upload-expiry"))
+	if err != nil {
+		return err
+	}
+
+	// 定期清理已结束的任务记录
+	if taskRetention > 0 {
+		stopJanitor := manager.StartJanitor(taskRetention, janitorInterval)
+		defer stopJanitor()
+		fmt.Printf("Ended download tasks are cleared after %s\n", taskRetention)
+	}
 
 	// 设置认证配置
 	var authConfig *auth.AuthConfig
-	if username != "" && password != "" {
+	if (username != "" && password != "") || usersFile != "" || tokensFile != "" || aclFile != "" || tlsOptions.ClientCAFile != "" {
 		authConfig = auth.NewAuthConfig(username, password)
+		if usersFile != "" {
+			users, err := auth.LoadUserStore(usersFile)
+			if err != nil {
+				return err
+			}
+			stopWatch := users.Watch(usersFileCheckInterval)
+			defer stopWatch()
+			authConfig.SetUserStore(users)
+			fmt.Printf("Loaded %d users from %s\n", len(users.Users()), usersFile)
+		}
+		if tokensFile != "" {
+			tokens, err := auth.LoadTokenStore(tokensFile)
+			if err != nil {
+				return err
+			}
+			stopWatch := tokens.Watch(usersFileCheckInterval)
+			defer stopWatch()
+			authConfig.SetTokenStore(tokens)
+			authConfig.SetPathScope("/:tasks", auth.ScopeDownloadTasks)
+			fmt.Printf("Loaded %d API tokens from %s\n", len(tokens.Tokens()), tokensFile)
+		}
+		if aclFile != "" {
+			acl, err := auth.LoadACL(aclFile)
+			if err != nil {
+				return err
+			}
+			authConfig.SetACL(acl)
+			fmt.Printf("Loaded %d access control rules from %s\n", len(acl.Rules), aclFile)
+		}
+		if sessionTTL > 0 {
+			authConfig.SetSessionStore(auth.NewSessionStore(sessionTTL))
+		}
+		if loginLimiter != nil {
+			authConfig.SetLoginLimiter(loginLimiter)
+		}
+		authConfig.SetAdmins(admins)
+		authConfig.SetClientCertAuth(tlsOptions.ClientCAFile != "")
 		authConfig.SetReadPermission(requireReadAuth)
 		authConfig.SetWritePermission(requireWriteAuth)
 
 		fmt.Printf("Authentication enabled:\n")
 		fmt.Printf("  Read operations: %s\n", map[bool]string{true: "requires auth", false: "public"}[requireReadAuth])
 		fmt.Printf("  Write operations: %s\n", map[bool]string{true: "requires auth", false: "public"}[requireWriteAuth])
+		if homeDirs {
+			fmt.Printf("  Users are confined to their home directories under %s\n", dir)
+		}
+		if tlsOptions.ClientCAFile != "" {
+			fmt.Printf("  TLS client certificates signed by %s are accepted as the user in their common name\n", tlsOptions.ClientCAFile)
+		}
+		if maxLoginFailures > 0 {
+			fmt.Printf("  Clients and users are locked out for %s after %d failed logins\n", loginLockout, maxLoginFailures)
+		}
 	} else {
-		fmt.Println("Authentication disabled (no username/password provided)")
+		fmt.Println("Authentication disabled (no username/password, users file, tokens file, acl file or client CA provided)")
+	}
+
+	// 配额，没有配置时 quotaManager 为 nil，不做限制
+	var quotaManager *quota.Manager
+	if quotaFile != "" {
+		config, err := quota.LoadConfig(quotaFile)
+		if err != nil {
+			return err
+		}
+		quotaManager = quota.NewManager(dir, config, func(username string) (string, error) {
+			return userRoot(authConfig, dir, homeDirs, username)
+		})
+		if err := quotaManager.CheckUsers(); err != nil {
+			return fmt.Errorf("%w, enable --home-dirs or set a home in the users file", err)
+		}
+		manager.SetQuota(quotaManager)
+		fmt.Printf("Loaded quotas of %d users and %d directories from %s\n", len(config.Users), len(config.Dirs), quotaFile)
 	}
 
 	r := gin.Default()
+	// 只有来自信任的代理的请求才使用 X-Forwarded-For 等请求头中的客户端 IP，默认不信任任何代理
+	if err := r.SetTrustedProxies(c.StringSlice("trusted-proxy")); err != nil {
+		return fmt.Errorf("invalid trusted proxy: %w", err)
+	}
 
-	// 添加认证中间件
+	// 审计日志最先处理，以便记录被认证拒绝的请求
+	var auditLog *AuditLog
+	if auditLogFile != "" {
+		auditLog, err = NewAuditLog(auditLogFile, c.Int64("audit-log-max-size"), c.Int("audit-log-max-files"))
+		if err != nil {
+			return err
+		}
+		defer auditLog.Close()
+		r.Use(auditMiddleware(auditLog))
+		fmt.Printf("Audit log written to %s\n", auditLogFile)
+	}
+
+	// requestRoot 返回当前请求的用户可见的根目录
+	requestRoot := func(c *gin.Context) (string, error) {
+		return userRoot(authConfig, dir, homeDirs, auth.UserFromRequest(c.Request))
+	}
+
+	// 分享链接由链接中的签名授权，不经过认证
+	r.Use(func(c *gin.Context) {
+		if strings.HasPrefix(c.Request.URL.Path, sharePrefix) {
+			shareManager.ServeShare(c)
+			c.Abort()
+			return
+		}
+		c.Next()
+	})
+
+	// 网页登录、退出和查询登录状态，未登录时也需要能访问
 	if authConfig != nil {
-		r.Use(authConfig.GinMiddleware())
+		r.Use(func(c *gin.Context) {
+			switch c.Request.URL.Path {
+			case "/:login":
+				authConfig.ServeLogin(c.Writer, c.Request)
+			case "/:logout":
+				authConfig.ServeLogout(c.Writer, c.Request)
+			case "/:session":
+				authConfig.ServeSession(c.Writer, c.Request)
+			default:
+				c.Next()
+				return
+			}
+			c.Abort()
+		})
 	}
 
 	mime.AddExtensionType(".apk", "application/vnd.android.package-archive")
@@ -370,21 +1600,37 @@ func start_server(c *cli.Context) error {
 		r.Use(func(c *gin.Context) {
 			if strings.HasPrefix(c.Request.URL.Path, "/$.dav$/") || c.Request.URL.Path == "/$.dav$" {
 				// Adjust the path for WebDAV handler
-				originalPath := c.Request.URL.Path
 				c.Request.URL.Path = strings.TrimPrefix(c.Request.URL.Path, "/$.dav$")
 				if c.Request.URL.Path == "" {
 					c.Request.URL.Path = "/"
 				}
 
-				// 检查WebDAV请求是否需要认证
-				if authConfig != nil && authConfig.IsAuthRequired(c.Request.Method, originalPath) {
-					username, password, hasAuth := c.Request.BasicAuth()
-					if !hasAuth || !authConfig.ValidateCredentials(username, password) {
-						c.Header("WWW-Authenticate", `Basic realm="`+authConfig.Realm+`"`)
-						c.AbortWithStatus(http.StatusUnauthorized)
+				// 按去掉前缀后的文件路径检查权限，MOVE、COPY 还需要对目标路径有写权限
+				if authConfig != nil {
+					req, status := authConfig.Check(c.Request, c.Request.URL.Path)
+					if status == 0 && req.Header.Get("Destination") != "" {
+						status = authConfig.Authorize(req.Method, webdavDestination(req), auth.UserFromRequest(req)).StatusCode()
+					}
+					c.Request = req
+					if status != 0 {
+						authConfig.Reject(c.Writer, c.Request, status)
+						c.Abort()
 						return
 					}
 				}
+				root, err := requestRoot(c)
+				if err != nil {
+					c.AbortWithError(500, err)
+					return
+				}
+				ctx := server.WithRoot(c.Request.Context(), root)
+				if quotaManager != nil {
+					username := auth.UserFromRequest(c.Request)
+					ctx = server.WithQuota(ctx, func(name string, delta int64, newFile bool) error {
+						return quotaManager.Reserve(username, name, delta, newFile)
+					})
+				}
+				c.Request = c.Request.WithContext(ctx)
 
 				webdavHandler.ServeHTTP(c.Writer, c.Request)
 				c.Abort()
@@ -395,12 +1641,55 @@ func start_server(c *cli.Context) error {
 		fmt.Printf("WebDAV enabled at /$.dav$/\n")
 	}
 
+	// 添加认证中间件，WebDAV 请求已在上面单独处理
+	if authConfig != nil {
+		r.Use(authConfig.GinMiddleware())
+
+		// 管理员查看和解除认证失败的锁定
+		r.Use(func(c *gin.Context) {
+			if c.Request.URL.Path != "/:lockouts" {
+				c.Next()
+				return
+			}
+			authConfig.ServeLockouts(c.Writer, c.Request)
+			c.Abort()
+		})
+	}
+
+	// 可续传的上传
+	r.Use(func(c *gin.Context) {
+		if c.Request.URL.Path != uploadPrefix && !strings.HasPrefix(c.Request.URL.Path, uploadPrefix+"/") {
+			c.Next()
+			return
+		}
+		root, err := requestRoot(c)
+		if err != nil {
+			c.String(500, err.Error())
+		} else {
+			handleUploads(c, authConfig, uploadManager, quotaManager, root)
+		}
+		c.Abort()
+	})
+
 	r.GET("/*uri", func(c *gin.Context) {
 		uri := c.Param("uri")
+		root, err := requestRoot(c)
+		if err != nil {
+			c.String(500, err.Error())
+			return
+		}
 		if uri == "/favicon.ico" {
 			c.Data(200, "image/svg+xml", favicon)
 			return
 		}
+		if uri == "/:tasks" {
+			handleWatchTasks(c, authConfig, root)
+			return
+		}
+		if uri == "/:audit" {
+			handleAuditQuery(c, authConfig, auditLog)
+			return
+		}
 
 		// Handle static files
 		if strings.HasPrefix(uri, "/static/") {
@@ -419,7 +1708,7 @@ func start_server(c *cli.Context) error {
 			}
 		}
 
-		filePath := path.Join(dir, uri)
+		filePath := path.Join(root, uri)
 		stat, err := os.Stat(filePath)
 		if err != nil {
 			c.String(404, "404 not found")
@@ -430,24 +1719,29 @@ func start_server(c *cli.Context) error {
 			_, ok := c.GetQuery("json")
 			if ok {
 				// return json
-				c.JSON(200, genJson(dir, uri))
+				c.JSON(200, genJson(root, uri))
 			} else {
-				c.Data(200, "text/html", []byte(genIndexHtml(dir, uri)))
+				c.Data(200, "text/html", []byte(genIndexHtml(root, uri)))
 			}
 			return
 		}
 
-		c.File(path.Join(dir, uri))
+		c.File(path.Join(root, uri))
 	})
 	r.POST("/*uri", func(c *gin.Context) {
 		uri := c.Param("uri")
+		root, err := requestRoot(c)
+		if err != nil {
+			c.String(500, err.Error())
+			return
+		}
 
 		if uri == "/:tasks" {
-			handleListTask(c)
+			handleListTask(c, authConfig, root)
 			return
 		}
 
-		filePath := path.Join(dir, uri)
+		filePath := path.Join(root, uri)
 		stat, err := os.Stat(filePath)
 		if err != nil {
 			c.String(404, "404 not found")
@@ -459,34 +1753,146 @@ func start_server(c *cli.Context) error {
 		}
 		form, err := c.MultipartForm()
 		if err == nil {
-			files := form.File["files"]
-			for _, file := range files {
-				c.SaveUploadedFile(file, path.Join(filePath, file.Filename))
+			entry := setAudit(c, "upload", uri)
+			uploads, err := formUploads(form, filePath)
+			if err != nil {
+				c.String(errorStatus(err), err.Error())
+				return
+			}
+			for _, upload := range uploads {
+				if upload.target != "" {
+					entry.Files = append(entry.Files, upload.name)
+				}
+			}
+			for _, upload := range uploads {
+				if upload.target != "" && !authorizeWrite(c, authConfig, auth.ScopeWrite, path.Join(uri, upload.name)) {
+					return
+				}
+			}
+			// 保存之前检查所有文件的配额
+			for _, upload := range uploads {
+				if upload.target == "" {
+					continue
+				}
+				if err := quotaManager.ReserveFile(auth.UserFromRequest(c.Request), upload.target, upload.file.Size); err != nil {
+					c.String(errorStatus(err), err.Error())
+					return
+				}
+			}
+			// 一个文件保存失败时继续保存其它文件，部分失败返回 207，全部失败返回 500
+			results := make([]UploadResult, len(uploads))
+			saved, failed := 0, 0
+			for i, upload := range uploads {
+				results[i] = UploadResult{Name: upload.file.Filename, Path: path.Join(uri, upload.name)}
+				if upload.target == "" {
+					results[i].Skipped = true
+					continue
+				}
+				err := os.MkdirAll(filepath.Dir(upload.target), 0755)
+				if err == nil {
+					results[i].Size, results[i].Sha256, err = saveUploadedFile(upload.file, upload.target)
+				}
+				if err != nil {
+					results[i].Error = err.Error()
+					failed++
+				} else {
+					saved++
+				}
+			}
+			status := http.StatusOK
+			if failed > 0 && saved > 0 {
+				status = http.StatusMultiStatus
+			} else if failed > 0 {
+				status = http.StatusInternalServerError
 			}
-			c.String(200, "200 ok")
+			c.JSON(status, UploadResponse{Files: results})
 			return
 		}
 
 		req := PostRequest{}
 		err = c.BindJSON(&req)
 		if err == nil {
+			if req.Method != "listShares" {
+				entry := setAudit(c, req.Method, path.Join(uri, req.Name))
+				if req.Method == "download" {
+					entry.Target = redactURL(req.Url)
+				} else if req.Method == "move" || req.Method == "copy" {
+					entry.Target = path.Join("/", req.Dest)
+				}
+			}
+			switch req.Method {
+			case "pauseTask", "resumeTask", "cancelTask", "retryTask", "clearTasks":
+				if !requireScope(c, authConfig, auth.ScopeDownloadTasks) {
+					return
+				}
+			}
+
 			if req.Method == "download" {
-				taskId, err := manager.AddTask(req.Url, filePath)
+				if !authorizeWrite(c, authConfig, auth.ScopeDownloadTasks, path.Join(uri, req.Name)) {
+					return
+				}
+				taskId, err := manager.AddTask(req.Url, filePath, DownloadOptions{
+					Name:       req.Name,
+					OnConflict: req.OnConflict,
+					Priority:   req.Priority,
+					Checksums: map[string]string{
+						"sha256": req.Sha256,
+						"sha1":   req.Sha1,
+						"md5":    req.Md5,
+					},
+					DeleteOnMismatch: req.DeleteOnMismatch,
+					Remote:           &req.RemoteOptions,
+					Retry:            req.Retry,
+					RateLimit:        req.RateLimit,
+					Owner:            auth.UserFromRequest(c.Request),
+					Root:             root,
+				})
 				if err != nil {
-					c.String(500, err.Error())
+					c.String(errorStatus(err), err.Error())
 				} else {
 					c.JSON(200, DownloadResponse{
-						TaskId: taskId,
+						TaskId:   taskId,
+						Filename: manager.GetTaskStatus(taskId).Filename,
+					})
+				}
+				return
+			} else if req.Method == "pauseTask" {
+				handleTaskOperation(c, authConfig, root, req.TaskId, manager.PauseTask)
+				return
+			} else if req.Method == "resumeTask" {
+				handleTaskOperation(c, authConfig, root, req.TaskId, manager.ResumeTask)
+				return
+			} else if req.Method == "cancelTask" {
+				handleTaskOperation(c, authConfig, root, req.TaskId, manager.CancelTask)
+				return
+			} else if req.Method == "retryTask" {
+				handleTaskOperation(c, authConfig, root, req.TaskId, manager.RetryTask)
+				return
+			} else if req.Method == "clearTasks" {
+				// 管理员可以清理根目录下所有的任务，其他用户只能清理自己添加的任务
+				user := auth.UserFromRequest(c.Request)
+				admin := authConfig != nil && authConfig.IsAdmin(user)
+				removed, err := manager.ClearEndedTasksWhere(func(task *DownloadTaskInfo) bool {
+					return taskAllowed(c, authConfig, root, task, http.MethodPost) && (admin || task.Owner == user)
+				}, time.Duration(req.OlderThan), req.Status)
+				if err != nil {
+					c.String(errorStatus(err), err.Error())
+				} else {
+					c.JSON(200, ClearTasksResponse{
+						Removed: removed,
 					})
 				}
 				return
 			} else if req.Method == "createDir" {
 				safeName := req.Name
 				createdDirPath := path.Join(filePath, safeName)
-				if !isSubDir(dir, createdDirPath) {
+				if !isSubDir(root, createdDirPath) {
 					c.String(400, "400 bad request")
 					return
 				}
+				if !authorizeWrite(c, authConfig, auth.ScopeWrite, path.Join(uri, safeName)) {
+					return
+				}
 
 				err = os.MkdirAll(createdDirPath, 0755)
 				if err != nil {
@@ -500,13 +1906,16 @@ func start_server(c *cli.Context) error {
 				return
 			} else if req.Method == "deleteFile" {
 				deletedFilePath := path.Join(filePath, req.Name)
+				// 先检查范围，不透露根目录之外的文件是否存在
+				if !isSubDir(root, deletedFilePath) {
+					c.String(400, "400 bad request")
+					return
+				}
 				if ok, _ := exists(deletedFilePath); !ok {
 					c.String(404, "file not found")
 					return
 				}
-
-				if !isSubDir(dir, deletedFilePath) {
-					c.String(400, "400 bad request")
+				if !authorizeWrite(c, authConfig, auth.ScopeWrite, path.Join(uri, req.Name)) {
 					return
 				}
 
@@ -517,7 +1926,122 @@ func start_server(c *cli.Context) error {
 					c.String(200, "200 ok")
 				}
 				return
+			} else if req.Method == "move" || req.Method == "copy" {
+				srcPath := path.Join(filePath, req.Name)
+				destUri := path.Join("/", req.Dest)
+				destPath := path.Join(root, destUri)
+				// 不能移动根目录，也不能移动到自身或子目录中
+				if !isSubDir(root, srcPath) || srcPath == path.Clean(root) || destPath == path.Clean(root) ||
+					isSubDir(srcPath, destPath) || slices.ContainsFunc(strings.Split(destUri, "/"), isTempFile) {
+					c.String(400, "400 bad request")
+					return
+				}
+				if ok, _ := exists(srcPath); !ok {
+					c.String(404, "file not found")
+					return
+				}
+				move := req.Method == "move"
+				// 移动需要删除源文件的权限，复制只需要读取
+				if move {
+					if !authorizeWrite(c, authConfig, auth.ScopeWrite, path.Join(uri, req.Name)) {
+						return
+					}
+				} else if !authorizeRead(c, authConfig, path.Join(uri, req.Name)) {
+					return
+				}
+				if !authorizeWrite(c, authConfig, auth.ScopeWrite, destUri) {
+					return
+				}
+
+				// 移动时按新位置检查用量，被替换的目标不再计入
+				username := auth.UserFromRequest(c.Request)
+				size := pathSize(srcPath)
+				replaced := int64(0)
+				if req.Overwrite {
+					replaced = pathSize(destPath)
+				}
+				if move {
+					quotaManager.Reserve(username, srcPath, -size, false)
+				}
+				if err := quotaManager.Reserve(username, destPath, size-replaced, !move); err != nil {
+					if move {
+						quotaManager.Reserve(username, srcPath, size, false)
+					}
+					c.String(errorStatus(err), err.Error())
+					return
+				}
+
+				if err := transferPath(srcPath, destPath, req.Overwrite, move); err != nil {
+					quotaManager.Reserve(username, destPath, replaced-size, false)
+					if move {
+						quotaManager.Reserve(username, srcPath, size, false)
+					}
+					c.String(errorStatus(err), err.Error())
+					return
+				}
+				c.JSON(200, MoveResponse{Path: destUri})
+				return
+			} else if req.Method == "share" {
+				sharedPath := path.Join(filePath, req.Name)
+				if !isSubDir(root, sharedPath) {
+					c.String(400, "400 bad request")
+					return
+				}
+				if ok, _ := exists(sharedPath); !ok {
+					c.String(404, "file not found")
+					return
+				}
+				if !authorizeRead(c, authConfig, path.Join(uri, req.Name)) {
+					return
+				}
+
+				share, err := shareManager.Create(sharedPath, auth.UserFromRequest(c.Request), ShareOptions{
+					Expires:      time.Duration(req.Expires),
+					MaxDownloads: req.MaxDownloads,
+					Password:     req.Password,
+				})
+				if err != nil {
+					c.String(errorStatus(err), err.Error())
+				} else {
+					c.JSON(200, share)
+				}
+				return
+			} else if req.Method == "listShares" {
+				if !requireScope(c, authConfig, auth.ScopeRead) {
+					return
+				}
+				c.JSON(200, ListSharesResponse{
+					Shares: shareManager.List(auth.UserFromRequest(c.Request)),
+				})
+				return
+			} else if req.Method == "revokeShare" {
+				if !requireScope(c, authConfig, auth.ScopeRead) {
+					return
+				}
+				if err := shareManager.Revoke(req.ShareId, auth.UserFromRequest(c.Request)); err != nil {
+					c.String(errorStatus(err), err.Error())
+				} else {
+					c.String(200, "200 ok")
+				}
+				return
 			} else if req.Method == "logging" {
+				logFile := path.Join(filePath, req.Name+".log")
+				if !isSubDir(root, logFile) {
+					c.String(400, "400 bad request")
+					return
+				}
+				if !authorizeWrite(c, authConfig, auth.ScopeWrite, path.Join(uri, req.Name+".log")) {
+					return
+				}
+				var size int64
+				for _, line := range req.Logs {
+					size += int64(len(line))
+				}
+				exist, _ := exists(logFile)
+				if err := quotaManager.Reserve(auth.UserFromRequest(c.Request), logFile, size, !exist); err != nil {
+					c.String(errorStatus(err), err.Error())
+					return
+				}
 				saveLog(filePath, req.Name, req.Logs)
 				c.String(200, "200 ok")
 				return
@@ -527,8 +2051,25 @@ func start_server(c *cli.Context) error {
 		c.String(400, "400 bad request")
 	})
 
-	r.Run(":" + port)
-	return nil
+	if tlsConfig == nil {
+		return r.Run(":" + port)
+	}
+
+	if redirectPort != "" {
+		go func() {
+			fmt.Printf("Redirecting HTTP on port %s to HTTPS\n", redirectPort)
+			if err := http.ListenAndServe(":"+redirectPort, redirectToHTTPS(port)); err != nil {
+				log.Printf("HTTP redirect server stopped: %v", err)
+			}
+		}()
+	}
+	httpServer := &http.Server{
+		Addr:      ":" + port,
+		Handler:   r,
+		TLSConfig: tlsConfig,
+	}
+	fmt.Printf("Serving HTTPS on port %s\n", port)
+	return httpServer.ListenAndServeTLS("", "")
 }
 
 func saveLog(dir string, name string, logs []string) {
@@ -586,9 +2127,58 @@ func main() {
 				Usage:   "username for authentication",
 			},
 			&cli.StringFlag{
-				Name:  "password",
+				Name:    "password",
+				Value:   "",
+				Usage:   "password for authentication",
+				EnvVars: []string{"FILESERVER_PASSWORD"},
+			},
+			&cli.StringFlag{
+				Name:  "users-file",
+				Value: "",
+				Usage: "htpasswd-style users file with bcrypt or argon2id hashes, reloaded on change (manage with the user command)",
+			},
+			&cli.StringFlag{
+				Name:  "tokens-file",
+				Value: "",
+				Usage: "JSON file with API tokens accepted as Authorization: Bearer, reloaded on change (manage with the token command); with --users-file, tokens of users not in it are rejected",
+			},
+			&cli.StringFlag{
+				Name:  "acl-file",
+				Value: "",
+				Usage: "JSON file with per-path access control rules",
+			},
+			&cli.StringFlag{
+				Name:  "quota-file",
 				Value: "",
-				Usage: "password for authentication",
+				Usage: "JSON file with byte and file count quotas per user and per top-level directory; a user quota limits the user's home directory, so users with quotas need one (--home-dirs or a home in the users file)",
+			},
+			&cli.BoolFlag{
+				Name:  "home-dirs",
+				Value: false,
+				Usage: "confine each authenticated user to dir/<username>, or the home set in the users file",
+			},
+			&cli.DurationFlag{
+				Name:  "session-ttl",
+				Value: 24 * time.Hour,
+				Usage: "lifetime of web UI login sessions (0 to disable login and use basic auth only)",
+			},
+			&cli.IntFlag{
+				Name:  "max-login-failures",
+				Value: 5,
+				Usage: "lock out a client IP, username or share link after this many failed logins or share passwords in a row (0 to disable)",
+			},
+			&cli.DurationFlag{
+				Name:  "login-lockout",
+				Value: time.Minute,
+				Usage: "duration of the first lockout, doubled after each further failure up to 1h",
+			},
+			&cli.StringSliceFlag{
+				Name:  "trusted-proxy",
+				Usage: "IP or CIDR of a reverse proxy whose X-Forwarded-For header is trusted for the client IP in audit logs",
+			},
+			&cli.StringSliceFlag{
+				Name:  "admin",
+				Usage: "users allowed to list and clear login lockouts at /:lockouts",
 			},
 			&cli.BoolFlag{
 				Name:  "auth-read",
@@ -600,8 +2190,97 @@ func main() {
 				Value: false,
 				Usage: "require authentication for write operations (upload, delete, create dir)",
 			},
+			&cli.StringFlag{
+				Name:  "audit-log",
+				Value: "",
+				Usage: "JSON lines file recording uploads, deletions, download tasks and WebDAV changes (disabled if empty)",
+			},
+			&cli.Int64Flag{
+				Name:  "audit-log-max-size",
+				Value: 10 << 20,
+				Usage: "rotate the audit log when it grows beyond this many bytes (0 to never rotate)",
+			},
+			&cli.IntFlag{
+				Name:  "audit-log-max-files",
+				Value: 5,
+				Usage: "number of rotated audit log files to keep",
+			},
+			&cli.StringFlag{
+				Name:  "tls-cert",
+				Value: "",
+				Usage: "PEM certificate file to serve HTTPS (requires --tls-key)",
+			},
+			&cli.StringFlag{
+				Name:  "tls-key",
+				Value: "",
+				Usage: "PEM private key file of the TLS certificate",
+			},
+			&cli.BoolFlag{
+				Name:  "tls-self-signed",
+				Value: false,
+				Usage: "generate a self-signed certificate if --tls-cert does not exist or has expired (stored in --state-dir if no files are given)",
+			},
+			&cli.StringFlag{
+				Name:  "http-redirect-port",
+				Value: "",
+				Usage: "also listen for plain HTTP on this port and redirect to HTTPS (disabled if empty)",
+			},
+			&cli.StringFlag{
+				Name:  "tls-client-ca",
+				Value: "",
+				Usage: "PEM CA certificates of TLS client certificates, which authenticate as the user in their common name",
+			},
+			&cli.BoolFlag{
+				Name:  "tls-require-client-cert",
+				Value: false,
+				Usage: "reject TLS connections without a client certificate signed by --tls-client-ca",
+			},
+			&cli.DurationFlag{
+				Name:  "upload-expiry",
+				Value: defaultUploadExpiry,
+				Usage: "remove unfinished resumable uploads after this long without new data",
+			},
+			&cli.StringFlag{
+				Name:  "state-dir",
+				Value: "",
+				Usage: "directory to persist download tasks, share links and unfinished uploads across restarts (disabled if empty)",
+			},
+			&cli.BoolFlag{
+				Name:  "resume-tasks",
+				Value: false,
+				Usage: "resume interrupted download tasks on startup (requires --state-dir)",
+			},
+			&cli.IntFlag{
+				Name:  "max-downloads",
+				Usage: "maximum number of concurrent download tasks, extra tasks are queued (0 for unlimited)",
+			},
+			&cli.Int64Flag{
+				Name:  "rate-limit",
+				Value: 0,
+				Usage: "total download rate limit of all tasks in bytes per second (0 for unlimited)",
+			},
+			&cli.IntFlag{
+				Name:  "retry-attempts",
+				Value: 3,
+				Usage: "maximum attempts of a download task including the first one (1 to disable retries)",
+			},
+			&cli.DurationFlag{
+				Name:  "retry-backoff",
+				Value: 2 * time.Second,
+				Usage: "wait time before the first retry, doubled after each retry",
+			},
+			&cli.DurationFlag{
+				Name:  "task-retention",
+				Usage: "remove finished, failed or canceled download tasks older than this (0 to keep forever)",
+			},
+		},
+		Commands: []*cli.Command{
+			userCommand,
+			tokenCommand,
 		},
 		Action: start_server,
 	}
-	app.Run(os.Args)
+	if err := app.Run(os.Args); err != nil {
+		log.Fatal(err)
+	}
 }
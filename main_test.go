@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/breezechen/go_file_server/auth"
+	"github.com/gin-gonic/gin"
+)
+
+// TestUserRoot 测试用户主目录的解析
+func TestUserRoot(t *testing.T) {
+	dir := t.TempDir()
+	usersFile := filepath.Join(t.TempDir(), "users")
+	auth.AddUser(usersFile, "alice", "secret")
+	auth.AddUser(usersFile, "bob", "secret")
+	auth.SetHome(usersFile, "bob", "shared/bob")
+	users, err := auth.LoadUserStore(usersFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authConfig := auth.NewAuthConfig("carol", "secret")
+	authConfig.SetUserStore(users)
+
+	tests := []struct {
+		username string
+		homeDirs bool
+		want     string
+	}{
+		{"", true, dir},
+		{"alice", false, dir},
+		{"alice", true, filepath.Join(dir, "alice")},
+		{"carol", true, filepath.Join(dir, "carol")},
+		// 用户文件中配置的主目录不需要启用 homeDirs
+		{"bob", false, filepath.Join(dir, "shared/bob")},
+	}
+	for _, tt := range tests {
+		root, err := userRoot(authConfig, dir, tt.homeDirs, tt.username)
+		if err != nil || root != tt.want {
+			t.Errorf("userRoot(%q, %v) = %q, %v, want %q", tt.username, tt.homeDirs, root, err, tt.want)
+			continue
+		}
+		if stat, err := os.Stat(root); err != nil || !stat.IsDir() {
+			t.Errorf("home directory %s was not created", root)
+		}
+	}
+
+	if root, _ := userRoot(nil, dir, true, "alice"); root != dir {
+		t.Errorf("userRoot without auth = %q, want %q", root, dir)
+	}
+	if _, err := userRoot(authConfig, dir, true, "../x"); err == nil {
+		t.Error("userRoot should reject usernames that escape dir")
+	}
+}
+
+// TestFormUploads 测试表单上传的相对路径和冲突策略
+func TestFormUploads(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644)
+	os.Mkdir(filepath.Join(dir, "docs"), 0755)
+
+	form := func(policy string, names []string, paths ...string) *multipart.Form {
+		f := &multipart.Form{
+			File:  map[string][]*multipart.FileHeader{},
+			Value: map[string][]string{"onConflict": {policy}, "relativePath": paths},
+		}
+		for _, name := range names {
+			f.File["files"] = append(f.File["files"], &multipart.FileHeader{Filename: name})
+		}
+		return f
+	}
+	tests := []struct {
+		name    string
+		form    *multipart.Form
+		want    []string
+		wantErr error
+	}{
+		{"overwrite", form("", []string{"a.txt", "b.txt"}), []string{"a.txt", "b.txt"}, nil},
+		{"skip", form("skip", []string{"a.txt", "b.txt"}), []string{"", "b.txt"}, nil},
+		{"rename", form("rename", []string{"a.txt", "a.txt"}), []string{"a_1.txt", "a_2.txt"}, nil},
+		{"fail", form("fail", []string{"b.txt", "a.txt"}), nil, ErrFileExists},
+		{"unknown policy", form("merge", []string{"a.txt"}), nil, ErrBadRequest},
+		{"folder", form("", []string{"a.txt", "b.txt"}, "photos/2024/a.txt", "photos/b.txt"), []string{"photos/2024/a.txt", "photos/b.txt"}, nil},
+		{"escape is kept inside dir", form("", []string{"a.txt"}, "../../etc/a.txt"), []string{"etc/a.txt"}, nil},
+		{"directory", form("", []string{"docs"}), nil, ErrFileExists},
+		{"temp file", form("", []string{"a.txt"}, tempFilePrefix+"x/a.txt"), nil, ErrBadRequest},
+		{"path count", form("", []string{"a.txt", "b.txt"}, "a.txt"), nil, ErrBadRequest},
+	}
+	for _, tt := range tests {
+		uploads, err := formUploads(tt.form, dir)
+		if tt.wantErr != nil {
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("%s: err = %v, want %v", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || len(uploads) != len(tt.want) {
+			t.Errorf("%s: formUploads() = %v, %v", tt.name, uploads, err)
+			continue
+		}
+		for i, upload := range uploads {
+			want := tt.want[i]
+			if want != "" {
+				want = filepath.Join(dir, filepath.FromSlash(want))
+			}
+			if upload.target != want {
+				t.Errorf("%s: target[%d] = %q, want %q", tt.name, i, upload.target, want)
+			}
+		}
+	}
+}
+
+// TestTaskACL 测试任务的查询和操作按任务目标文件的 ACL 检查权限，而不是请求的路径
+func TestTaskACL(t *testing.T) {
+	saved := manager
+	defer func() { manager = saved }()
+	manager = newTestManager(t, 0)
+	now := time.Now()
+	for _, task := range []*DownloadTaskInfo{
+		{TaskId: "team", Filepath: "team-a/big.bin", Owner: "alice", Status: &DownloadStatus{Status: "paused"}, StartedAt: &now},
+		{TaskId: "shared", Filepath: "shared/doc.bin", Owner: "alice", Status: &DownloadStatus{Status: "paused"}, StartedAt: &now},
+	} {
+		manager.Tasks[task.TaskId] = task
+	}
+
+	usersFile := filepath.Join(t.TempDir(), "users")
+	auth.AddUser(usersFile, "alice", "secret")
+	auth.AddUser(usersFile, "bob", "secret")
+	users, err := auth.LoadUserStore(usersFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authConfig := auth.NewAuthConfig("", "")
+	authConfig.SetUserStore(users)
+	authConfig.SetACL(&auth.ACL{
+		Groups: map[string][]string{"team-a": {"alice"}},
+		Rules: []*auth.Rule{
+			{Path: "/team-a/**", Write: []string{"group:team-a"}},
+			{Path: "/shared/**", Read: []string{"authenticated"}, Write: []string{"alice"}},
+			{Path: "/public/**", Write: []string{"authenticated"}},
+		},
+	})
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(authConfig.GinMiddleware())
+	r.POST("/:tasks", func(c *gin.Context) {
+		handleListTask(c, authConfig, rootDir)
+	})
+	r.POST("/public/", func(c *gin.Context) {
+		handleTaskOperation(c, authConfig, rootDir, c.Query("taskId"), func(string) error { return nil })
+	})
+	do := func(user, url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", url, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.SetBasicAuth(user, "secret")
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+
+	listed := func(user string) []string {
+		rec := do(user, "/:tasks", `{"or":[{}]}`)
+		var resp ListTaskResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		var ids []string
+		for _, task := range resp.Tasks {
+			ids = append(ids, task.TaskId)
+		}
+		sort.Strings(ids)
+		return ids
+	}
+	if got := listed("alice"); strings.Join(got, ",") != "shared,team" {
+		t.Errorf("alice's tasks = %v", got)
+	}
+	if got := listed("bob"); strings.Join(got, ",") != "shared" {
+		t.Errorf("bob's tasks = %v, want only shared", got)
+	}
+
+	tests := []struct {
+		user, taskId string
+		want         int
+	}{
+		{"alice", "team", 200},
+		{"bob", "team", 404},
+		{"bob", "shared", 403},
+		{"alice", "shared", 200},
+	}
+	for _, tt := range tests {
+		if rec := do(tt.user, "/public/?taskId="+tt.taskId, ""); rec.Code != tt.want {
+			t.Errorf("%s operating on %s: status = %d, want %d", tt.user, tt.taskId, rec.Code, tt.want)
+		}
+	}
+}
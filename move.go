@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/google/uuid"
+)
+
+// tempPathFor 返回与 dst 在同一目录中的临时路径，目录列表中不显示，中断后在启动时被清理
+func tempPathFor(dst string) string {
+	return filepath.Join(filepath.Dir(dst), tempFilePrefix+uuid.New().String())
+}
+
+// transferPath 将文件或目录 src 移动（move 为 true）或复制到 dst，缺少的上级目录会被创建。
+// dst 已存在时只有 overwrite 为 true 才替换，替换失败时保留原来的 dst
+func transferPath(src, dst string, overwrite, move bool) error {
+	if _, err := os.Lstat(dst); err == nil && !overwrite {
+		return fmt.Errorf("%w: %s", ErrFileExists, relToRoot(dst))
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	// 先放到目标目录中的临时位置，其它用户不会看到复制了一半的文件
+	tmp := tempPathFor(dst)
+	renamed := move && os.Rename(src, tmp) == nil
+	if !renamed {
+		// 复制，或移动时不在同一文件系统
+		if err := copyPath(src, tmp); err != nil {
+			os.RemoveAll(tmp)
+			return err
+		}
+	}
+	if err := replacePath(tmp, dst); err != nil {
+		if renamed {
+			os.Rename(tmp, src)
+		} else {
+			os.RemoveAll(tmp)
+		}
+		return err
+	}
+	if move && !renamed {
+		return os.RemoveAll(src)
+	}
+	return nil
+}
+
+// replacePath 将 tmp 重命名为 dst，dst 已存在时先移开，成功后删除原来的 dst
+func replacePath(tmp, dst string) error {
+	old := ""
+	if _, err := os.Lstat(dst); err == nil {
+		old = tempPathFor(dst)
+		if err := os.Rename(dst, old); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		if old != "" {
+			os.Rename(old, dst)
+		}
+		return err
+	}
+	if old != "" {
+		if err := os.RemoveAll(old); err != nil {
+			log.Printf("failed to remove replaced file %s: %v", old, err)
+		}
+	}
+	return nil
+}
+
+// copyPath 复制文件或目录，保留权限，符号链接复制为指向相同位置的链接
+func copyPath(src, dst string) error {
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return os.Mkdir(target, info.Mode().Perm())
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case d.Type().IsRegular():
+			return copyFile(p, target, info.Mode().Perm())
+		default:
+			// 设备、管道等特殊文件不复制
+			return nil
+		}
+	})
+}
+
+// copyFile 将普通文件 src 复制为新文件 dst
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// pathSize 返回文件或目录中所有普通文件的总大小，不存在时为 0
+func pathSize(name string) int64 {
+	var size int64
+	filepath.WalkDir(name, func(_ string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestTransferPath 测试移动和复制文件、目录以及替换已存在的目标
+func TestTransferPath(t *testing.T) {
+	root := t.TempDir()
+	write := func(name, content string) {
+		os.MkdirAll(filepath.Dir(filepath.Join(root, name)), 0755)
+		os.WriteFile(filepath.Join(root, name), []byte(content), 0644)
+	}
+	read := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(root, name))
+		if err != nil {
+			return "<missing>"
+		}
+		return string(data)
+	}
+	write("a.txt", "a")
+	write("b.txt", "b")
+	write("dir/sub/c.txt", "c")
+
+	tests := []struct {
+		name      string
+		src, dst  string
+		overwrite bool
+		move      bool
+		wantErr   error
+		want      map[string]string
+	}{
+		{"copy file", "a.txt", "copies/a.txt", false, false, nil, map[string]string{"a.txt": "a", "copies/a.txt": "a"}},
+		{"existing target", "a.txt", "b.txt", false, true, ErrFileExists, map[string]string{"a.txt": "a", "b.txt": "b"}},
+		{"overwrite", "a.txt", "b.txt", true, true, nil, map[string]string{"a.txt": "<missing>", "b.txt": "a"}},
+		{"copy dir", "dir", "dir2", false, false, nil, map[string]string{"dir/sub/c.txt": "c", "dir2/sub/c.txt": "c"}},
+		{"move dir over file", "dir2", "b.txt", true, true, nil, map[string]string{"b.txt/sub/c.txt": "c", "dir2/sub/c.txt": "<missing>"}},
+	}
+	for _, tt := range tests {
+		err := transferPath(filepath.Join(root, tt.src), filepath.Join(root, tt.dst), tt.overwrite, tt.move)
+		if (tt.wantErr == nil && err != nil) || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.wantErr)
+		}
+		for name, want := range tt.want {
+			if got := read(name); got != want {
+				t.Errorf("%s: %s = %q, want %q", tt.name, name, got, want)
+			}
+		}
+	}
+
+	// 不留下临时文件
+	if removed := cleanTempFiles(root, nil); removed != 0 {
+		t.Errorf("%d temporary files left behind", removed)
+	}
+}
@@ -0,0 +1,228 @@
+package quota
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrExceeded 写入后会超过配额
+	ErrExceeded = errors.New("quota exceeded")
+	// ErrSharedRoot 用户的根目录是服务的根目录，用户配额会统计所有用户的文件
+	ErrSharedRoot = errors.New("user quota needs a home directory")
+)
+
+// 缓存的目录用量超过该时间后重新统计，期间按写入的预留量累加
+const scanInterval = time.Minute
+
+// Limit 一个目录的配额，0 表示不限制
+type Limit struct {
+	// 所有文件的总字节数
+	Bytes int64 `json:"bytes"`
+	// 文件数量，不包括目录
+	Files int64 `json:"files"`
+}
+
+// Config 配额文件的内容
+type Config struct {
+	// 用户的配额，限制用户根目录（启用主目录时为用户的主目录）的用量。
+	// 按目录而不是文件的所有者统计，用户的根目录不能是服务的根目录
+	Users map[string]Limit `json:"users"`
+	// 顶层目录的配额，键为根目录下的目录名
+	Dirs map[string]Limit `json:"dirs"`
+}
+
+// LoadConfig 读取 JSON 格式的配额文件
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("invalid quota file %s: %w", path, err)
+	}
+
+	dirs := make(map[string]Limit, len(config.Dirs))
+	for name, limit := range config.Dirs {
+		clean := strings.Trim(filepath.ToSlash(filepath.Clean(name)), "/")
+		if clean == "" || clean == "." || clean == ".." || strings.Contains(clean, "/") {
+			return nil, fmt.Errorf("invalid quota directory %q: must be a top-level directory name", name)
+		}
+		dirs[clean] = limit
+	}
+	config.Dirs = dirs
+	for name, limit := range config.Users {
+		if limit.Bytes < 0 || limit.Files < 0 {
+			return nil, fmt.Errorf("invalid quota of user %s: limits must not be negative", name)
+		}
+	}
+	for name, limit := range dirs {
+		if limit.Bytes < 0 || limit.Files < 0 {
+			return nil, fmt.Errorf("invalid quota of directory %s: limits must not be negative", name)
+		}
+	}
+	return &config, nil
+}
+
+// usage 目录的用量
+type usage struct {
+	bytes     int64
+	files     int64
+	scannedAt time.Time
+}
+
+// Manager 按配置检查写入是否超过配额，方法在 Manager 为 nil 时不做限制
+type Manager struct {
+	root   string
+	config *Config
+	// userRoot 返回用户的根目录，用户配额限制该目录的用量
+	userRoot func(username string) (string, error)
+
+	mu    sync.Mutex
+	usage map[string]*usage
+}
+
+// NewManager 创建配额管理器，root 为服务的根目录，userRoot 返回用户的根目录
+func NewManager(root string, config *Config, userRoot func(username string) (string, error)) *Manager {
+	return &Manager{
+		root:     filepath.Clean(root),
+		config:   config,
+		userRoot: userRoot,
+		usage:    make(map[string]*usage),
+	}
+}
+
+// CheckUsers 检查有配额的用户都有自己的根目录，用户的根目录为服务的根目录时返回 ErrSharedRoot
+func (m *Manager) CheckUsers() error {
+	names := make([]string, 0, len(m.config.Users))
+	for name := range m.config.Users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, err := m.userDir(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// userDir 返回用户配额限制的目录，用户的根目录为服务的根目录时返回 ErrSharedRoot
+func (m *Manager) userDir(username string) (string, error) {
+	dir, err := m.userRoot(username)
+	if err != nil {
+		return "", err
+	}
+	dir = filepath.Clean(dir)
+	if dir == m.root {
+		return "", fmt.Errorf("%w: user %s", ErrSharedRoot, username)
+	}
+	return dir, nil
+}
+
+// scope 一个适用于写入的配额
+type scope struct {
+	label string
+	dir   string
+	limit Limit
+}
+
+// scopes 返回 username 写入 name 时适用的配额
+func (m *Manager) scopes(username, name string) ([]scope, error) {
+	var scopes []scope
+	if limit, ok := m.config.Users[username]; ok && username != "" {
+		// 用户文件中的主目录被删除后同样拒绝写入，避免用别人的文件计算该用户的用量
+		dir, err := m.userDir(username)
+		if err != nil {
+			return nil, err
+		}
+		scopes = append(scopes, scope{"user " + username, dir, limit})
+	}
+
+	rel, err := filepath.Rel(m.root, filepath.Clean(name))
+	if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		// 只有顶层目录中的文件受目录配额限制，根目录下的文件不受限制
+		if top, _, ok := strings.Cut(filepath.ToSlash(rel), "/"); ok {
+			if limit, ok := m.config.Dirs[top]; ok {
+				scopes = append(scopes, scope{"directory " + top, filepath.Join(m.root, top), limit})
+			}
+		}
+	}
+	return scopes, nil
+}
+
+// Reserve 检查 username 向 name（文件系统中的完整路径）写入 delta 字节后是否超过配额，newFile 表示会新建文件。
+// 未超过时将写入计入用量，超过时返回 ErrExceeded
+func (m *Manager) Reserve(username, name string, delta int64, newFile bool) error {
+	if m == nil {
+		return nil
+	}
+	scopes, err := m.scopes(username, name)
+	if err != nil || len(scopes) == 0 {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	usages := make([]*usage, len(scopes))
+	for i, s := range scopes {
+		usages[i] = m.usageLocked(s.dir)
+		u := usages[i]
+		if s.limit.Bytes > 0 && delta > 0 && u.bytes+delta > s.limit.Bytes {
+			return fmt.Errorf("%w: %s would use %d of %d bytes", ErrExceeded, s.label, u.bytes+delta, s.limit.Bytes)
+		}
+		if s.limit.Files > 0 && newFile && u.files+1 > s.limit.Files {
+			return fmt.Errorf("%w: %s would have %d of %d files", ErrExceeded, s.label, u.files+1, s.limit.Files)
+		}
+	}
+	for _, u := range usages {
+		u.bytes += delta
+		if newFile {
+			u.files++
+		}
+	}
+	return nil
+}
+
+// ReserveFile 检查 username 将 name 写成 size 字节后是否超过配额，name 已存在时只计算增加的部分
+func (m *Manager) ReserveFile(username, name string, size int64) error {
+	if m == nil {
+		return nil
+	}
+	stat, err := os.Stat(name)
+	if err != nil {
+		return m.Reserve(username, name, size, true)
+	}
+	return m.Reserve(username, name, size-stat.Size(), false)
+}
+
+// usageLocked 返回目录的用量，缓存过期时重新统计，调用方需持有 m.mu
+func (m *Manager) usageLocked(dir string) *usage {
+	u := m.usage[dir]
+	if u != nil && time.Since(u.scannedAt) < scanInterval {
+		return u
+	}
+	u = &usage{scannedAt: time.Now()}
+	filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		// 无法读取的文件和目录不计入用量
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			u.bytes += info.Size()
+			u.files++
+		}
+		return nil
+	})
+	m.usage[dir] = u
+	return u
+}
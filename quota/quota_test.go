@@ -0,0 +1,91 @@
+package quota
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestManager 测试用户配额和顶层目录配额
+func TestManager(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "alice"), 0755)
+	os.MkdirAll(filepath.Join(root, "team", "sub"), 0755)
+	os.WriteFile(filepath.Join(root, "alice", "a.txt"), make([]byte, 60), 0644)
+	os.WriteFile(filepath.Join(root, "team", "sub", "t.txt"), make([]byte, 10), 0644)
+
+	file := filepath.Join(t.TempDir(), "quota.json")
+	os.WriteFile(file, []byte(`{
+		"users": {"alice": {"bytes": 100, "files": 2}},
+		"dirs": {"/team/": {"files": 2}}
+	}`), 0644)
+	config, err := LoadConfig(file)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	m := NewManager(root, config, func(username string) (string, error) {
+		return filepath.Join(root, username), nil
+	})
+
+	tests := []struct {
+		username, name string
+		size           int64
+		wantErr        bool
+	}{
+		// alice 已使用 60 字节和 1 个文件
+		{"alice", "alice/b.txt", 50, true},
+		{"alice", "alice/b.txt", 40, false},
+		// 文件数量已达上限，覆盖已有文件只计算增加的字节
+		{"alice", "alice/c.txt", 0, true},
+		{"alice", "alice/a.txt", 60, false},
+		// 没有配额的用户和根目录下的文件不受限制
+		{"bob", "big.bin", 1 << 30, false},
+		{"bob", "team/sub/u.txt", 1 << 20, false},
+		{"bob", "team/v.txt", 1, true},
+	}
+	for _, tt := range tests {
+		err := m.ReserveFile(tt.username, filepath.Join(root, tt.name), tt.size)
+		if tt.wantErr != (err != nil) || (err != nil && !errors.Is(err, ErrExceeded)) {
+			t.Errorf("ReserveFile(%s, %s, %d) = %v, wantErr %v", tt.username, tt.name, tt.size, err, tt.wantErr)
+		}
+	}
+
+	// 写入减少用量后可以继续写入
+	if err := m.Reserve("alice", filepath.Join(root, "alice", "b.txt"), -40, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Reserve("alice", filepath.Join(root, "alice", "a.txt"), 40, false); err != nil {
+		t.Errorf("Reserve after freeing space: %v", err)
+	}
+
+	var nilManager *Manager
+	if err := nilManager.ReserveFile("alice", filepath.Join(root, "x"), 1<<40); err != nil {
+		t.Errorf("nil manager should not limit writes: %v", err)
+	}
+
+	// 用户的根目录为服务的根目录时，配额会统计所有用户的文件
+	shared := NewManager(root, config, func(username string) (string, error) {
+		return root, nil
+	})
+	if err := shared.CheckUsers(); !errors.Is(err, ErrSharedRoot) {
+		t.Errorf("CheckUsers() with shared root = %v, want ErrSharedRoot", err)
+	}
+	if err := shared.ReserveFile("alice", filepath.Join(root, "x"), 1); !errors.Is(err, ErrSharedRoot) {
+		t.Errorf("ReserveFile with shared root = %v, want ErrSharedRoot", err)
+	}
+	if err := m.CheckUsers(); err != nil {
+		t.Errorf("CheckUsers() = %v", err)
+	}
+
+	for _, content := range []string{
+		`{"dirs": {"team/sub": {"bytes": 1}}}`,
+		`{"dirs": {"..": {"bytes": 1}}}`,
+		`{"users": {"alice": {"bytes": -1}}}`,
+	} {
+		os.WriteFile(file, []byte(content), 0644)
+		if _, err := LoadConfig(file); err == nil {
+			t.Errorf("LoadConfig(%s) should fail", content)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// 限速时单次读取的最大字节数，读得越少速度越平滑
+const throttleReadSize = 16 * 1024
+
+// rateLimiter 是按字节计算的令牌桶，最多积攒一秒的额度，rate 为 0 表示不限速
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   int64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate int64) *rateLimiter {
+	return &rateLimiter{rate: rate}
+}
+
+// limit 返回当前的限速（字节/秒）
+func (l *rateLimiter) limit() int64 {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+// setLimit 修改限速，对正在进行的下载立即生效
+func (l *rateLimiter) setLimit(rate int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate = rate
+	l.tokens = 0
+	l.last = time.Now()
+}
+
+// wait 扣除 n 个字节的额度，额度不足时等待到补足为止
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	now := time.Now()
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * float64(l.rate)
+	}
+	if l.tokens > float64(l.rate) {
+		l.tokens = float64(l.rate)
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / float64(l.rate) * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if delay == 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// throttledBody 读取响应体时按 limiters 限速
+type throttledBody struct {
+	io.ReadCloser
+	ctx      context.Context
+	limiters []*rateLimiter
+}
+
+func (b *throttledBody) Read(p []byte) (int, error) {
+	if len(p) > throttleReadSize {
+		p = p[:throttleReadSize]
+	}
+	n, err := b.ReadCloser.Read(p)
+	for _, l := range b.limiters {
+		if werr := l.wait(b.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// throttleTransport 为响应体加上限速
+type throttleTransport struct {
+	base     http.RoundTripper
+	limiters []*rateLimiter
+}
+
+func (t *throttleTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err == nil {
+		resp.Body = &throttledBody{ReadCloser: resp.Body, ctx: req.Context(), limiters: t.limiters}
+	}
+	return resp, err
+}
+
+// throttle 包装 client，使下载的数据同时受 limiters 中所有限速的约束
+func throttle(client *http.Client, limiters ...*rateLimiter) *http.Client {
+	base := http.DefaultTransport
+	if client != nil && client.Transport != nil {
+		base = client.Transport
+	}
+
+	wrapped := &http.Client{}
+	if client != nil {
+		*wrapped = *client
+	}
+	wrapped.Transport = &throttleTransport{base: base, limiters: limiters}
+	return wrapped
+}
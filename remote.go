@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/melbahja/got"
+)
+
+// RemoteOptions 是访问下载源时使用的请求参数
+type RemoteOptions struct {
+	Headers   map[string]string `json:"headers,omitempty"`
+	Cookies   map[string]string `json:"cookies,omitempty"`
+	BasicAuth *BasicAuth        `json:"basicAuth,omitempty"`
+	UserAgent string            `json:"userAgent,omitempty"`
+	// Proxy 为代理地址，支持 http、https 和 socks5
+	Proxy string `json:"proxy,omitempty"`
+}
+
+// BasicAuth 是下载源的基础认证凭据
+type BasicAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// isEmpty 判断是否没有设置任何参数
+func (r *RemoteOptions) isEmpty() bool {
+	return r == nil || (len(r.Headers) == 0 && len(r.Cookies) == 0 && r.BasicAuth == nil &&
+		r.UserAgent == "" && r.Proxy == "")
+}
+
+// validate 检查请求头和代理地址
+func (r *RemoteOptions) validate() error {
+	if r == nil {
+		return nil
+	}
+	for key, value := range r.Headers {
+		if key == "" || strings.ContainsAny(key, " :\r\n") || strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("%w: invalid header: %s", ErrBadRequest, key)
+		}
+	}
+	for name, value := range r.Cookies {
+		if name == "" || strings.ContainsAny(name, " =;\r\n") || strings.ContainsAny(value, ";\r\n") {
+			return fmt.Errorf("%w: invalid cookie: %s", ErrBadRequest, name)
+		}
+	}
+	if r.Proxy != "" {
+		u, err := url.Parse(r.Proxy)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5") {
+			// 代理地址可能带有凭据，错误信息中不输出
+			return fmt.Errorf("%w: invalid proxy url", ErrBadRequest)
+		}
+	}
+	return nil
+}
+
+// withURLCredentials 将 URL 中的用户名和密码移到 BasicAuth（已设置 BasicAuth 时丢弃），
+// 返回去掉凭据的 URL 和请求参数，凭据不会出现在任务列表中
+func (r *RemoteOptions) withURLCredentials(rawUrl string) (string, *RemoteOptions) {
+	u, err := url.Parse(rawUrl)
+	if err != nil || u.User == nil {
+		return rawUrl, r
+	}
+	password, _ := u.User.Password()
+	credentials := &BasicAuth{Username: u.User.Username(), Password: password}
+	u.User = nil
+
+	remote := &RemoteOptions{}
+	if r != nil {
+		*remote = *r
+	}
+	if remote.BasicAuth == nil {
+		remote.BasicAuth = credentials
+	}
+	return u.String(), remote
+}
+
+// apply 将请求参数设置到 got 下载上
+func (r *RemoteOptions) apply(d *got.Download) {
+	if r == nil {
+		return
+	}
+
+	for key, value := range r.Headers {
+		d.Header = append(d.Header, got.GotHeader{Key: key, Value: value})
+	}
+	if len(r.Cookies) > 0 {
+		names := make([]string, 0, len(r.Cookies))
+		for name := range r.Cookies {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		cookies := make([]string, 0, len(names))
+		for _, name := range names {
+			cookies = append(cookies, name+"="+r.Cookies[name])
+		}
+		d.Header = append(d.Header, got.GotHeader{Key: "Cookie", Value: strings.Join(cookies, "; ")})
+	}
+	if r.BasicAuth != nil {
+		credentials := r.BasicAuth.Username + ":" + r.BasicAuth.Password
+		d.Header = append(d.Header, got.GotHeader{
+			Key:   "Authorization",
+			Value: "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials)),
+		})
+	}
+	if r.UserAgent != "" {
+		d.Header = append(d.Header, got.GotHeader{Key: "User-Agent", Value: r.UserAgent})
+	}
+
+	if r.Proxy != "" {
+		if proxyURL, err := url.Parse(r.Proxy); err == nil {
+			d.Client = &http.Client{
+				Transport: &http.Transport{
+					MaxIdleConns:        10,
+					IdleConnTimeout:     30 * time.Second,
+					TLSHandshakeTimeout: 5 * time.Second,
+					Proxy:               http.ProxyURL(proxyURL),
+				},
+			}
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/breezechen/go_file_server/quota"
+)
+
+// 保留的尝试记录数上限
+const maxAttemptHistory = 20
+
+// defaultRetryableStatusCodes 默认视为临时错误的 HTTP 状态码
+var defaultRetryableStatusCodes = []int{
+	http.StatusRequestTimeout,
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// Duration 在 JSON 中以 "2s"、"1m30s" 的形式表示时长，也接受以秒为单位的数字
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var seconds float64
+	if err := json.Unmarshal(data, &seconds); err == nil {
+		*d = Duration(seconds * float64(time.Second))
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+// RetryPolicy 是下载失败后的自动重试策略，零值字段使用全局策略
+type RetryPolicy struct {
+	// MaxAttempts 为包括第一次在内的最多尝试次数，1 表示不重试
+	MaxAttempts int `json:"maxAttempts,omitempty"`
+	// Backoff 为第一次重试前的等待时间，之后每次翻倍，不超过 MaxBackoff
+	Backoff    Duration `json:"backoff,omitempty"`
+	MaxBackoff Duration `json:"maxBackoff,omitempty"`
+	// RetryableStatusCodes 为可重试的 HTTP 状态码，网络错误总是可重试
+	RetryableStatusCodes []int `json:"retryableStatusCodes,omitempty"`
+}
+
+// DefaultRetryPolicy 返回默认的全局重试策略
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:          3,
+		Backoff:              Duration(2 * time.Second),
+		MaxBackoff:           Duration(time.Minute),
+		RetryableStatusCodes: defaultRetryableStatusCodes,
+	}
+}
+
+// validate 检查策略中的取值
+func (p *RetryPolicy) validate() error {
+	if p == nil {
+		return nil
+	}
+	if p.MaxAttempts < 0 || p.Backoff < 0 || p.MaxBackoff < 0 {
+		return fmt.Errorf("%w: retry policy values must not be negative", ErrBadRequest)
+	}
+	for _, code := range p.RetryableStatusCodes {
+		if code < 400 || code > 599 {
+			return fmt.Errorf("%w: invalid retryable status code: %d", ErrBadRequest, code)
+		}
+	}
+	return nil
+}
+
+// merge 用任务自己的策略覆盖全局策略中对应的字段
+func (p RetryPolicy) merge(override *RetryPolicy) RetryPolicy {
+	if override == nil {
+		return p
+	}
+	if override.MaxAttempts > 0 {
+		p.MaxAttempts = override.MaxAttempts
+	}
+	if override.Backoff > 0 {
+		p.Backoff = override.Backoff
+	}
+	if override.MaxBackoff > 0 {
+		p.MaxBackoff = override.MaxBackoff
+	}
+	if override.RetryableStatusCodes != nil {
+		p.RetryableStatusCodes = override.RetryableStatusCodes
+	}
+	return p
+}
+
+// backoff 返回第 retries+1 次重试前的等待时间
+func (p RetryPolicy) backoff(retries int) time.Duration {
+	delay := time.Duration(p.Backoff)
+	for i := 0; i < retries && (p.MaxBackoff <= 0 || delay < time.Duration(p.MaxBackoff)); i++ {
+		delay *= 2
+	}
+	if p.MaxBackoff > 0 && delay > time.Duration(p.MaxBackoff) {
+		delay = time.Duration(p.MaxBackoff)
+	}
+	return delay
+}
+
+// retryable 判断错误是否可以通过重试恢复，statusCode 为下载源最后返回的错误状态码
+func (p RetryPolicy) retryable(err error, statusCode int) bool {
+	if statusCode != 0 {
+		for _, code := range p.RetryableStatusCodes {
+			if code == statusCode {
+				return true
+			}
+		}
+		return false
+	}
+
+	// 本地文件错误、校验失败和超过配额重试也无法恢复
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) || errors.Is(err, ErrChecksumMismatch) || errors.Is(err, quota.ErrExceeded) {
+		return false
+	}
+	return true
+}
+
+// DownloadAttempt 记录任务的一次运行
+type DownloadAttempt struct {
+	StartedAt  time.Time  `json:"startedAt"`
+	EndAt      *time.Time `json:"endAt"`
+	Error      string     `json:"error,omitempty"`
+	StatusCode int        `json:"statusCode,omitempty"`
+}
+
+// statusTransport 记录下载源最后一次返回的错误状态码，供判断是否重试
+type statusTransport struct {
+	base   http.RoundTripper
+	status *int32
+}
+
+func (t *statusTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err == nil && resp.StatusCode >= 400 {
+		atomic.StoreInt32(t.status, int32(resp.StatusCode))
+	}
+	return resp, err
+}
+
+// recordStatus 包装 client，使错误状态码记录到 status 中
+func recordStatus(client *http.Client, status *int32) *http.Client {
+	base := http.DefaultTransport
+	if client != nil && client.Transport != nil {
+		base = client.Transport
+	}
+
+	wrapped := &http.Client{}
+	if client != nil {
+		*wrapped = *client
+	}
+	wrapped.Transport = &statusTransport{base: base, status: status}
+	return wrapped
+}
+
+// endAttempt 记录任务最近一次运行的结束时间和错误，err 为 nil 表示成功或被暂停、取消
+func endAttempt(task *DownloadTaskInfo, err error, statusCode int) {
+	if len(task.Attempts) == 0 {
+		return
+	}
+	attempt := &task.Attempts[len(task.Attempts)-1]
+	timeNow := time.Now()
+	attempt.EndAt = &timeNow
+	if err != nil {
+		attempt.Error = err.Error()
+		attempt.StatusCode = statusCode
+	}
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/breezechen/go_file_server/auth"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	shareStoreFile = "shares.json"
+	shareKeyFile   = "share.key"
+	// sharePrefix 分享链接的路径前缀，之后为 "<分享ID>.<过期时间>.<签名>/<文件名或子路径>"
+	sharePrefix = "/:share/"
+	// 未指定有效期时分享链接的有效期
+	defaultShareExpiry = 24 * time.Hour
+	// 比较折算的下载次数时允许的浮点误差
+	downloadEpsilon = 1e-9
+)
+
+// ErrShareNotFound 分享不存在、已过期或已被撤销
+var ErrShareNotFound = errors.New("share not found")
+
+// ShareLink 文件或目录的分享链接，持有链接的人不需要登录即可下载
+type ShareLink struct {
+	Id string `json:"id"`
+	// 相对 rootDir 的路径
+	Path      string    `json:"path"`
+	IsDir     bool      `json:"isDir"`
+	Owner     string    `json:"owner,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	// 最大下载次数，0 表示不限制
+	MaxDownloads int `json:"maxDownloads,omitempty"`
+	// 下载次数按传输的字节数折算为完整文件的次数，向上取整
+	Downloads   int  `json:"downloads"`
+	HasPassword bool `json:"hasPassword"`
+	// 分享链接，包含签名和过期时间
+	Url string `json:"url"`
+
+	passwordHash string
+	// 已传输的字节数折算成的下载次数，Range 请求按请求的部分计算
+	served float64
+}
+
+// ShareOptions 创建分享链接的参数
+type ShareOptions struct {
+	// 有效期，为 0 时使用 defaultShareExpiry
+	Expires      time.Duration
+	MaxDownloads int
+	// 访问密码，为空时不需要密码
+	Password string
+}
+
+type ListSharesResponse struct {
+	Shares []*ShareLink `json:"shares"`
+}
+
+// storedShare 是保存到文件中的分享，额外保存接口中不返回的密码哈希
+type storedShare struct {
+	*ShareLink
+	PasswordHash string  `json:"passwordHash,omitempty"`
+	Served       float64 `json:"served,omitempty"`
+}
+
+// ShareManager 管理分享链接，设置了状态目录时分享和签名密钥保存在其中，重启后链接仍然有效
+type ShareManager struct {
+	// 保存分享的文件，为空时只保存在内存中
+	path string
+	key  []byte
+
+	mu     sync.Mutex
+	shares map[string]*ShareLink
+	// 密码错误次数过多时锁定客户端和分享，为空时不限制
+	limiter *auth.LoginLimiter
+}
+
+// NewShareManager 创建分享管理器，stateDir 为空时分享和签名密钥只保存在内存中
+func NewShareManager(stateDir string) (*ShareManager, error) {
+	sm := &ShareManager{shares: make(map[string]*ShareLink)}
+	if stateDir == "" {
+		sm.key = make([]byte, 32)
+		if _, err := rand.Read(sm.key); err != nil {
+			return nil, err
+		}
+		return sm, nil
+	}
+
+	if err := os.MkdirAll(stateDir, 0755); err != nil {
+		return nil, err
+	}
+	key, err := loadShareKey(filepath.Join(stateDir, shareKeyFile))
+	if err != nil {
+		return nil, err
+	}
+	sm.key = key
+	sm.path = filepath.Join(stateDir, shareStoreFile)
+
+	data, err := os.ReadFile(sm.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return sm, nil
+		}
+		return nil, err
+	}
+	var list []storedShare
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	for _, stored := range list {
+		if stored.ShareLink == nil || stored.Id == "" {
+			continue
+		}
+		stored.passwordHash = stored.PasswordHash
+		stored.served = max(stored.Served, float64(stored.Downloads))
+		sm.shares[stored.Id] = stored.ShareLink
+	}
+	return sm, nil
+}
+
+// loadShareKey 读取签名密钥，文件不存在时生成新的密钥
+func loadShareKey(file string) ([]byte, error) {
+	data, err := os.ReadFile(file)
+	if err == nil {
+		return hex.DecodeString(strings.TrimSpace(string(data)))
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(file, []byte(hex.EncodeToString(key)), 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// persistLocked 保存所有分享，调用方需持有 sm.mu
+func (sm *ShareManager) persistLocked() {
+	if sm.path == "" {
+		return
+	}
+	list := make([]storedShare, 0, len(sm.shares))
+	for _, share := range sm.shares {
+		list = append(list, storedShare{ShareLink: share, PasswordHash: share.passwordHash, Served: share.served})
+	}
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err == nil {
+		tmpPath := sm.path + ".tmp"
+		if err = os.WriteFile(tmpPath, data, 0600); err == nil {
+			err = os.Rename(tmpPath, sm.path)
+		}
+	}
+	if err != nil {
+		log.Printf("failed to save shares: %v", err)
+	}
+}
+
+// sign 计算分享 ID 和过期时间的签名
+func (sm *ShareManager) sign(id string, expires int64) string {
+	mac := hmac.New(sha256.New, sm.key)
+	fmt.Fprintf(mac, "%s.%d", id, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// usable 判断分享是否仍然有效
+func (share *ShareLink) usable() bool {
+	return time.Now().Before(share.ExpiresAt) && (share.MaxDownloads == 0 || share.served < float64(share.MaxDownloads)-downloadEpsilon)
+}
+
+// pruneLocked 删除已过期或下载次数已用完的分享，调用方需持有 sm.mu
+func (sm *ShareManager) pruneLocked() {
+	removed := false
+	for id, share := range sm.shares {
+		if !share.usable() {
+			delete(sm.shares, id)
+			removed = true
+		}
+	}
+	if removed {
+		sm.persistLocked()
+	}
+}
+
+// Create 为 target（文件系统中的完整路径）创建分享链接
+func (sm *ShareManager) Create(target, owner string, opts ShareOptions) (*ShareLink, error) {
+	if opts.Expires < 0 {
+		return nil, fmt.Errorf("%w: expires must not be negative", ErrBadRequest)
+	}
+	if opts.MaxDownloads < 0 {
+		return nil, fmt.Errorf("%w: maxDownloads must not be negative", ErrBadRequest)
+	}
+	if opts.Expires == 0 {
+		opts.Expires = defaultShareExpiry
+	}
+	stat, err := os.Stat(target)
+	if err != nil {
+		return nil, err
+	}
+
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return nil, err
+	}
+	share := &ShareLink{
+		Id:           hex.EncodeToString(idBytes),
+		Path:         relToRoot(target),
+		IsDir:        stat.IsDir(),
+		Owner:        owner,
+		CreatedAt:    time.Now(),
+		MaxDownloads: opts.MaxDownloads,
+		HasPassword:  opts.Password != "",
+	}
+	share.ExpiresAt = share.CreatedAt.Add(opts.Expires)
+	if opts.Password != "" {
+		if share.passwordHash, err = auth.HashPassword(opts.Password); err != nil {
+			return nil, err
+		}
+	}
+	expires := share.ExpiresAt.Unix()
+	share.Url = fmt.Sprintf("%s%s.%d.%s/", sharePrefix, share.Id, expires, sm.sign(share.Id, expires))
+	if !share.IsDir {
+		share.Url += url.PathEscape(filepath.Base(target))
+	}
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.pruneLocked()
+	sm.shares[share.Id] = share
+	sm.persistLocked()
+	copied := *share
+	return &copied, nil
+}
+
+// List 返回 owner 创建的仍然有效的分享，按创建时间排序
+func (sm *ShareManager) List(owner string) []*ShareLink {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.pruneLocked()
+
+	shares := make([]*ShareLink, 0)
+	for _, share := range sm.shares {
+		if share.Owner == owner {
+			copied := *share
+			shares = append(shares, &copied)
+		}
+	}
+	sort.Slice(shares, func(i, j int) bool {
+		return shares[i].CreatedAt.Before(shares[j].CreatedAt)
+	})
+	return shares
+}
+
+// Revoke 撤销 owner 创建的分享
+func (sm *ShareManager) Revoke(id, owner string) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	share := sm.shares[id]
+	if share == nil || share.Owner != owner {
+		return fmt.Errorf("%w: %s", ErrShareNotFound, id)
+	}
+	delete(sm.shares, id)
+	sm.persistLocked()
+	return nil
+}
+
+// resolve 校验链接中 "<分享ID>.<过期时间>.<签名>" 部分，返回对应的有效分享
+func (sm *ShareManager) resolve(token string) (*ShareLink, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrShareNotFound
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || !hmac.Equal([]byte(parts[2]), []byte(sm.sign(parts[0], expires))) {
+		return nil, ErrShareNotFound
+	}
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	share := sm.shares[parts[0]]
+	if share == nil || share.ExpiresAt.Unix() != expires || !share.usable() {
+		return nil, ErrShareNotFound
+	}
+	copied := *share
+	return &copied, nil
+}
+
+// SetLoginLimiter 设置分享密码的失败次数限制，与登录共用时同一客户端的失败次数一起计算
+func (sm *ShareManager) SetLoginLimiter(limiter *auth.LoginLimiter) {
+	sm.limiter = limiter
+}
+
+// countDownload 记录传输了文件的 fraction（1 为完整的文件），剩余的下载次数不够时返回 false
+func (sm *ShareManager) countDownload(id string, fraction float64) bool {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	share := sm.shares[id]
+	if share == nil || !share.usable() {
+		return false
+	}
+	if share.MaxDownloads > 0 && share.served+fraction > float64(share.MaxDownloads)+downloadEpsilon {
+		return false
+	}
+	share.served += fraction
+	share.Downloads = int(math.Ceil(share.served - downloadEpsilon))
+	sm.persistLocked()
+	return true
+}
+
+// ServeShare 处理分享链接的请求，不经过认证中间件，由链接中的签名授权
+func (sm *ShareManager) ServeShare(c *gin.Context) {
+	if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+		c.String(405, "405 method not allowed")
+		return
+	}
+
+	token, rest, _ := strings.Cut(strings.TrimPrefix(c.Request.URL.Path, sharePrefix), "/")
+	share, err := sm.resolve(token)
+	if err != nil {
+		// 过期、撤销和伪造的链接不加区分，避免泄露分享是否存在
+		c.String(404, "404 not found")
+		return
+	}
+	if share.HasPassword {
+		_, password, ok := c.Request.BasicAuth()
+		valid, wait, err := sm.limiter.Verify(c.Request, "share:"+share.Id, func() bool {
+			return ok && auth.VerifyPassword(share.passwordHash, password)
+		})
+		if errors.Is(err, auth.ErrLockedOut) {
+			c.Header("Retry-After", strconv.Itoa(max(1, int(wait.Round(time.Second).Seconds()))))
+			c.String(http.StatusTooManyRequests, "429 too many requests")
+			return
+		}
+		if !valid {
+			c.Header("WWW-Authenticate", `Basic realm="Shared link"`)
+			c.String(401, "401 unauthorized")
+			return
+		}
+	}
+
+	shareRoot := share.Path
+	if !filepath.IsAbs(shareRoot) {
+		shareRoot = filepath.Join(rootDir, shareRoot)
+	}
+	target, sub := shareRoot, "/"
+	if share.IsDir {
+		// path.Clean 保证子路径不会超出分享的目录
+		sub = path.Clean("/" + rest)
+		target = filepath.Join(shareRoot, filepath.FromSlash(sub))
+	}
+	stat, err := os.Stat(target)
+	if err != nil {
+		c.String(404, "404 not found")
+		return
+	}
+
+	if stat.IsDir() {
+		// 目录页面中的链接是相对路径，需要以 / 结尾
+		if !strings.HasSuffix(c.Request.URL.Path, "/") {
+			c.Redirect(http.StatusMovedPermanently, c.Request.URL.Path+"/")
+			return
+		}
+		if _, ok := c.GetQuery("json"); ok {
+			c.JSON(200, genJson(shareRoot, sub))
+		} else {
+			c.Data(200, "text/html", []byte(genIndexHtml(shareRoot, sub)))
+		}
+		return
+	}
+
+	// 按请求的字节数计入下载次数，断点续传的各个请求合计为一次
+	if c.Request.Method == http.MethodGet && !sm.countDownload(share.Id, requestedFraction(c.Request, stat.Size())) {
+		c.String(404, "404 not found")
+		return
+	}
+	c.FileAttachment(target, stat.Name())
+}
+
+// requestedFraction 返回请求的字节数占文件大小的比例，没有 Range、带有 If-Range 或无法解析时为整个文件
+func requestedFraction(r *http.Request, size int64) float64 {
+	header := r.Header.Get("Range")
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || size <= 0 || r.Header.Get("If-Range") != "" {
+		return 1
+	}
+	var total int64
+	for _, part := range strings.Split(spec, ",") {
+		start, end, ok := strings.Cut(strings.TrimSpace(part), "-")
+		if !ok {
+			return 1
+		}
+		if start == "" {
+			// bytes=-N 请求最后 N 个字节
+			n, err := strconv.ParseInt(end, 10, 64)
+			if err != nil || n < 0 {
+				return 1
+			}
+			total += min(n, size)
+			continue
+		}
+		first, err := strconv.ParseInt(start, 10, 64)
+		if err != nil || first < 0 {
+			return 1
+		}
+		last := size - 1
+		if end != "" {
+			if last, err = strconv.ParseInt(end, 10, 64); err != nil || last < first {
+				return 1
+			}
+			last = min(last, size-1)
+		}
+		if first < size {
+			total += last - first + 1
+		}
+	}
+	// 重叠的范围超过文件大小时按整个文件计算
+	return float64(min(total, size)) / float64(size)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/breezechen/go_file_server/auth"
+	"github.com/gin-gonic/gin"
+)
+
+// TestShareManager 测试分享链接的签名、密码、下载次数、目录分享和撤销
+func TestShareManager(t *testing.T) {
+	rootDir = t.TempDir()
+	stateDir := t.TempDir()
+	os.MkdirAll(filepath.Join(rootDir, "docs", "sub"), 0755)
+	os.WriteFile(filepath.Join(rootDir, "secret.txt"), []byte("secret"), 0644)
+	os.WriteFile(filepath.Join(rootDir, "report.txt"), []byte("report"), 0644)
+	os.WriteFile(filepath.Join(rootDir, "docs", "sub", "a.txt"), []byte("a"), 0644)
+
+	sm, err := NewShareManager(stateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(func(c *gin.Context) {
+		sm.ServeShare(c)
+		c.Abort()
+	})
+	get := func(url, password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", url, nil)
+		if password != "" {
+			req.SetBasicAuth("", password)
+		}
+		rec := httptest.NewRecorder()
+		engine.ServeHTTP(rec, req)
+		return rec
+	}
+
+	file, err := sm.Create(filepath.Join(rootDir, "report.txt"), "alice", ShareOptions{MaxDownloads: 1, Password: "pw"})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if !strings.HasPrefix(file.Url, sharePrefix) || !strings.HasSuffix(file.Url, "/report.txt") {
+		t.Errorf("Url = %s", file.Url)
+	}
+	if rec := get(file.Url, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("without password: status = %d, want 401", rec.Code)
+	}
+	if rec := get(file.Url, "pw"); rec.Code != http.StatusOK || rec.Body.String() != "report" {
+		t.Errorf("with password: status = %d, body = %q", rec.Code, rec.Body.String())
+	}
+	if rec := get(file.Url, "pw"); rec.Code != http.StatusNotFound {
+		t.Errorf("after max downloads: status = %d, want 404", rec.Code)
+	}
+
+	dir, err := sm.Create(filepath.Join(rootDir, "docs"), "alice", ShareOptions{})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if rec := get(dir.Url+"sub/a.txt", ""); rec.Code != http.StatusOK || rec.Body.String() != "a" {
+		t.Errorf("file in shared dir: status = %d, body = %q", rec.Code, rec.Body.String())
+	}
+	if rec := get(dir.Url+"sub", ""); rec.Code != http.StatusMovedPermanently {
+		t.Errorf("dir without slash: status = %d, want 301", rec.Code)
+	}
+	if rec := get(dir.Url+"../secret.txt", ""); rec.Code == http.StatusOK {
+		t.Error("share should not expose files outside the shared dir")
+	}
+	tampered := strings.Replace(dir.Url, dir.Id, strings.Repeat("0", len(dir.Id)), 1)
+	if rec := get(tampered, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("tampered link: status = %d, want 404", rec.Code)
+	}
+
+	// 重启后链接仍然有效
+	restored, err := NewShareManager(stateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shares := restored.List("alice"); len(shares) != 1 || shares[0].Id != dir.Id {
+		t.Errorf("restored shares = %+v, want only %s", shares, dir.Id)
+	}
+	if _, err := restored.resolve(strings.Split(strings.TrimPrefix(dir.Url, sharePrefix), "/")[0]); err != nil {
+		t.Errorf("restored link should be valid: %v", err)
+	}
+
+	if err := sm.Revoke(dir.Id, "bob"); !errors.Is(err, ErrShareNotFound) {
+		t.Errorf("revoking other's share: err = %v, want ErrShareNotFound", err)
+	}
+	if err := sm.Revoke(dir.Id, "alice"); err != nil {
+		t.Fatalf("Revoke failed: %v", err)
+	}
+	if rec := get(dir.Url, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("revoked link: status = %d, want 404", rec.Code)
+	}
+}
+
+// TestShareDownloadLimits 测试 Range 请求按字节数计入下载次数，以及密码错误次数过多时锁定
+func TestShareDownloadLimits(t *testing.T) {
+	rootDir = t.TempDir()
+	os.WriteFile(filepath.Join(rootDir, "data.bin"), []byte("0123456789"), 0644)
+
+	sm, err := NewShareManager("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm.SetLoginLimiter(auth.NewLoginLimiter(2, time.Minute))
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(func(c *gin.Context) {
+		sm.ServeShare(c)
+		c.Abort()
+	})
+	get := func(url, rangeHeader, password string) int {
+		req := httptest.NewRequest("GET", url, nil)
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+		if password != "" {
+			req.SetBasicAuth("", password)
+		}
+		rec := httptest.NewRecorder()
+		engine.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	share, err := sm.Create(filepath.Join(rootDir, "data.bin"), "", ShareOptions{MaxDownloads: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 分两段续传的下载合计为一次
+	if code := get(share.Url, "bytes=0-4", ""); code != http.StatusPartialContent {
+		t.Fatalf("first half: status = %d", code)
+	}
+	if code := get(share.Url, "bytes=5-", ""); code != http.StatusPartialContent {
+		t.Fatalf("second half: status = %d", code)
+	}
+	if code := get(share.Url, "bytes=-1", ""); code != http.StatusNotFound {
+		t.Errorf("suffix range after the limit: status = %d, want 404", code)
+	}
+
+	share, _ = sm.Create(filepath.Join(rootDir, "data.bin"), "", ShareOptions{MaxDownloads: 1})
+	for i := 0; i < 2; i++ {
+		if code := get(share.Url, "bytes=-5", ""); code != http.StatusPartialContent {
+			t.Fatalf("suffix range %d: status = %d", i, code)
+		}
+	}
+	if code := get(share.Url, "bytes=-5", ""); code != http.StatusNotFound {
+		t.Errorf("repeated suffix ranges should use up the downloads, status = %d", code)
+	}
+
+	share, _ = sm.Create(filepath.Join(rootDir, "data.bin"), "", ShareOptions{Password: "pw"})
+	for i := 0; i < 2; i++ {
+		if code := get(share.Url, "", "wrong"); code != http.StatusUnauthorized {
+			t.Errorf("wrong password: status = %d, want 401", code)
+		}
+	}
+	if code := get(share.Url, "", "pw"); code != http.StatusTooManyRequests {
+		t.Errorf("after failed attempts: status = %d, want 429", code)
+	}
+}
+
+// TestRequestedFraction 测试 Range 请求的字节数折算
+func TestRequestedFraction(t *testing.T) {
+	tests := []struct {
+		header string
+		want   float64
+	}{
+		{"", 1},
+		{"bytes=0-49", 0.5},
+		{"bytes=50-", 0.5},
+		{"bytes=-10", 0.1},
+		{"bytes=0-9,90-99", 0.2},
+		{"bytes=0-,0-", 1},
+		{"bytes=200-", 0},
+		{"items=0-1", 1},
+		{"bytes=x-1", 1},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Range", tt.header)
+		if got := requestedFraction(req, 100); got != tt.want {
+			t.Errorf("requestedFraction(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
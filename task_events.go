@@ -0,0 +1,98 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// 没有变化时定期发送的心跳间隔，避免连接被代理断开
+const taskEventKeepalive = 15 * time.Second
+
+// TaskWatcher 接收任务状态的变化，同一任务未取走的旧状态会被新状态覆盖，接收方处理得慢也不会阻塞下载
+type TaskWatcher struct {
+	dm *DownloadManager
+	// taskIds 为空时接收所有任务的变化
+	taskIds map[string]bool
+
+	mu      sync.Mutex
+	pending map[string]*DownloadTaskInfo
+	order   []string
+	notify  chan struct{}
+}
+
+// Watch 订阅任务状态的变化，taskIds 为空时订阅所有任务，用完后需要调用 Close
+func (dm *DownloadManager) Watch(taskIds []string) *TaskWatcher {
+	w := &TaskWatcher{
+		dm:      dm,
+		pending: make(map[string]*DownloadTaskInfo),
+		notify:  make(chan struct{}, 1),
+	}
+	if len(taskIds) > 0 {
+		w.taskIds = make(map[string]bool, len(taskIds))
+		for _, taskId := range taskIds {
+			w.taskIds[taskId] = true
+		}
+	}
+
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	dm.watchers[w] = struct{}{}
+	return w
+}
+
+// C 在有新的变化时可读
+func (w *TaskWatcher) C() <-chan struct{} {
+	return w.notify
+}
+
+// Drain 取走所有未读的变化，按任务第一次变化的顺序返回
+func (w *TaskWatcher) Drain() []*DownloadTaskInfo {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	tasks := make([]*DownloadTaskInfo, 0, len(w.order))
+	for _, taskId := range w.order {
+		tasks = append(tasks, w.pending[taskId])
+	}
+	w.pending = make(map[string]*DownloadTaskInfo)
+	w.order = nil
+	return tasks
+}
+
+// Close 取消订阅
+func (w *TaskWatcher) Close() {
+	w.dm.mu.Lock()
+	defer w.dm.mu.Unlock()
+	delete(w.dm.watchers, w)
+}
+
+// push 记录任务的最新状态并通知接收方
+func (w *TaskWatcher) push(task *DownloadTaskInfo) {
+	if w.taskIds != nil && !w.taskIds[task.TaskId] {
+		return
+	}
+
+	w.mu.Lock()
+	if _, ok := w.pending[task.TaskId]; !ok {
+		w.order = append(w.order, task.TaskId)
+	}
+	w.pending[task.TaskId] = task
+	w.mu.Unlock()
+
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+// notifyLocked 将任务当前的状态推送给所有订阅者，调用方需持有 dm.mu
+func (dm *DownloadManager) notifyLocked(taskId string) {
+	task := dm.Tasks[taskId]
+	if task == nil || len(dm.watchers) == 0 {
+		return
+	}
+	snapshot := task.snapshot()
+	for w := range dm.watchers {
+		w.push(snapshot)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const taskStoreFile = "tasks.json"
+
+// TaskStore 将下载任务持久化到状态目录下的 JSON 文件中
+type TaskStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+// storedTask 是保存到文件中的任务，额外保存接口中不返回的访问凭据
+type storedTask struct {
+	*DownloadTaskInfo
+	Remote *RemoteOptions `json:"remote,omitempty"`
+}
+
+// NewTaskStore 在 stateDir 下创建任务存储，目录不存在时自动创建
+func NewTaskStore(stateDir string) (*TaskStore, error) {
+	if err := os.MkdirAll(stateDir, 0755); err != nil {
+		return nil, err
+	}
+	return &TaskStore{
+		path: filepath.Join(stateDir, taskStoreFile),
+	}, nil
+}
+
+// Load 读取已保存的任务，文件不存在时返回空表
+func (s *TaskStore) Load() (map[string]*DownloadTaskInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tasks := make(map[string]*DownloadTaskInfo)
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return tasks, nil
+		}
+		return nil, err
+	}
+
+	var list []storedTask
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	for _, stored := range list {
+		task := stored.DownloadTaskInfo
+		if task == nil || task.TaskId == "" {
+			continue
+		}
+		task.Remote = stored.Remote
+		if task.Status == nil {
+			task.Status = &DownloadStatus{}
+		}
+		tasks[task.TaskId] = task
+	}
+	return tasks, nil
+}
+
+// Save 写入全部任务，先写临时文件再重命名，避免中途崩溃留下损坏的文件
+func (s *TaskStore) Save(tasks []*DownloadTaskInfo) error {
+	list := make([]storedTask, 0, len(tasks))
+	for _, task := range tasks {
+		list = append(list, storedTask{DownloadTaskInfo: task, Remote: task.Remote})
+	}
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tmpPath := s.path + ".tmp"
+	// 文件中可能保存有下载源的凭据，只允许当前用户读取
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, s.path)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	selfSignedCertFile = "tls-cert.pem"
+	selfSignedKeyFile  = "tls-key.pem"
+	// 自签名证书的有效期，过期后启动时重新生成
+	selfSignedValidity = 365 * 24 * time.Hour
+)
+
+// TLSOptions HTTPS 服务的配置
+type TLSOptions struct {
+	CertFile string
+	KeyFile  string
+	// 证书文件不存在或已过期时生成自签名证书并保存到 CertFile 和 KeyFile
+	SelfSigned bool
+	// 签发客户端证书的 CA，不为空时启用双向 TLS
+	ClientCAFile string
+	// 要求所有连接提供客户端证书，否则只验证提供了的证书
+	RequireClientCert bool
+}
+
+// Enabled 判断是否启用 HTTPS
+func (o *TLSOptions) Enabled() bool {
+	return o.CertFile != "" || o.KeyFile != "" || o.SelfSigned
+}
+
+// Config 加载证书，返回 HTTPS 服务使用的 TLS 配置
+func (o *TLSOptions) Config() (*tls.Config, error) {
+	if o.CertFile == "" || o.KeyFile == "" {
+		return nil, errors.New("both TLS certificate and key files are required")
+	}
+	if o.SelfSigned {
+		if err := ensureSelfSignedCert(o.CertFile, o.KeyFile); err != nil {
+			return nil, err
+		}
+	}
+	cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if o.ClientCAFile != "" {
+		data, err := os.ReadFile(o.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", o.ClientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		if o.RequireClientCert {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	} else if o.RequireClientCert {
+		return nil, errors.New("requiring client certificates needs a client CA file")
+	}
+	return config, nil
+}
+
+// ensureSelfSignedCert 证书和私钥文件都不存在或证书快要过期时生成新的自签名证书。
+// 文件存在但无法加载时返回错误，不覆盖用户提供的文件
+func ensureSelfSignedCert(certFile, keyFile string) error {
+	certExists, err := exists(certFile)
+	if err != nil {
+		return err
+	}
+	keyExists, err := exists(keyFile)
+	if err != nil {
+		return err
+	}
+	if certExists || keyExists {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return fmt.Errorf("failed to parse TLS certificate: %w", err)
+		}
+		if time.Now().Add(24 * time.Hour).Before(leaf.NotAfter) {
+			return nil
+		}
+	}
+
+	certPEM, keyPEM, err := generateSelfSignedCert(selfSignedHosts(), time.Now(), selfSignedValidity)
+	if err != nil {
+		return err
+	}
+	for _, file := range []string{certFile, keyFile} {
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		return err
+	}
+	if err := os.WriteFile(certFile, certPEM, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Generated self-signed TLS certificate %s\n", certFile)
+	return nil
+}
+
+// selfSignedHosts 返回自签名证书包含的主机名和 IP 地址
+func selfSignedHosts() []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" && hostname != "localhost" {
+		hosts = append(hosts, hostname)
+	}
+	return hosts
+}
+
+// generateSelfSignedCert 生成 hosts 使用的 ECDSA 自签名证书，返回 PEM 格式的证书和私钥
+func generateSelfSignedCert(hosts []string, notBefore time.Time, validity time.Duration) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"fileserver"}, CommonName: hosts[0]},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(validity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// redirectToHTTPS 将 HTTP 请求重定向到 httpsPort 端口上的同一地址
+func redirectToHTTPS(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		} else if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+			host = "[" + host + "]"
+		}
+		// 307 和 308 要求客户端保持请求方法和内容
+		status := http.StatusMovedPermanently
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			status = http.StatusPermanentRedirect
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), status)
+	})
+}
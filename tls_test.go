@@ -0,0 +1,151 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestSelfSignedCert 测试自签名证书的生成和复用
+func TestSelfSignedCert(t *testing.T) {
+	dir := t.TempDir()
+	options := &TLSOptions{
+		CertFile:   filepath.Join(dir, "tls", selfSignedCertFile),
+		KeyFile:    filepath.Join(dir, "tls", selfSignedKeyFile),
+		SelfSigned: true,
+	}
+	config, err := options.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(config.Certificates[0].Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := leaf.VerifyHostname("localhost"); err != nil {
+		t.Errorf("certificate should be valid for localhost: %v", err)
+	}
+	if err := leaf.VerifyHostname("127.0.0.1"); err != nil {
+		t.Errorf("certificate should be valid for 127.0.0.1: %v", err)
+	}
+	if stat, err := os.Stat(options.KeyFile); err != nil || stat.Mode().Perm() != 0600 {
+		t.Errorf("key file should only be readable by the owner: %v", err)
+	}
+
+	// 证书仍然有效时不重新生成
+	before, _ := os.ReadFile(options.CertFile)
+	if _, err := options.Config(); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := os.ReadFile(options.CertFile); string(after) != string(before) {
+		t.Error("valid certificate should be reused")
+	}
+
+	// 已过期的证书被替换
+	certPEM, keyPEM, err := generateSelfSignedCert([]string{"localhost"}, time.Now().Add(-48*time.Hour), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(options.CertFile, certPEM, 0644)
+	os.WriteFile(options.KeyFile, keyPEM, 0600)
+	if _, err := options.Config(); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := os.ReadFile(options.CertFile); string(after) == string(certPEM) {
+		t.Error("expired certificate should be regenerated")
+	}
+
+	// 无法加载的证书不被覆盖
+	invalid := &TLSOptions{
+		CertFile:   filepath.Join(dir, "user", "cert.pem"),
+		KeyFile:    filepath.Join(dir, "user", "key.pem"),
+		SelfSigned: true,
+	}
+	os.MkdirAll(filepath.Join(dir, "user"), 0755)
+	os.WriteFile(invalid.CertFile, certPEM, 0644)
+	os.WriteFile(invalid.KeyFile, []byte("not a key"), 0600)
+	if _, err := invalid.Config(); err == nil {
+		t.Error("invalid key should fail")
+	}
+	if after, _ := os.ReadFile(invalid.CertFile); string(after) != string(certPEM) {
+		t.Error("certificate with an invalid key should not be overwritten")
+	}
+	if data, _ := os.ReadFile(invalid.KeyFile); string(data) != "not a key" {
+		t.Error("invalid key should not be overwritten")
+	}
+
+	// 不生成证书时证书文件必须存在
+	if _, err := (&TLSOptions{CertFile: filepath.Join(dir, "missing.pem"), KeyFile: options.KeyFile}).Config(); err == nil {
+		t.Error("missing certificate should fail")
+	}
+	if _, err := (&TLSOptions{CertFile: options.CertFile, KeyFile: options.KeyFile, RequireClientCert: true}).Config(); err == nil {
+		t.Error("requiring client certificates without a CA should fail")
+	}
+}
+
+// TestClientCertTLS 测试双向 TLS 的客户端证书验证方式
+func TestClientCertTLS(t *testing.T) {
+	dir := t.TempDir()
+	serverCert, serverKey := writeTestCert(t, dir, "server")
+	caFile, _ := writeTestCert(t, dir, "ca")
+
+	options := &TLSOptions{CertFile: serverCert, KeyFile: serverKey, ClientCAFile: caFile}
+	config, err := options.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ClientAuth != tls.VerifyClientCertIfGiven || config.ClientCAs == nil {
+		t.Errorf("client certificates should be verified if given, got %v", config.ClientAuth)
+	}
+	options.RequireClientCert = true
+	if config, err = options.Config(); err != nil || config.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Errorf("client certificates should be required, got %v, %v", config.ClientAuth, err)
+	}
+
+	empty := filepath.Join(dir, "empty.pem")
+	os.WriteFile(empty, []byte("not a certificate"), 0644)
+	options.ClientCAFile = empty
+	if _, err := options.Config(); err == nil {
+		t.Error("CA file without certificates should fail")
+	}
+}
+
+// writeTestCert 生成自签名证书，返回证书和私钥文件的路径
+func writeTestCert(t *testing.T, dir, name string) (string, string) {
+	certPEM, keyPEM, err := generateSelfSignedCert([]string{"localhost"}, time.Now(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, name+".pem"), filepath.Join(dir, name+"-key.pem")
+	os.WriteFile(certFile, certPEM, 0644)
+	os.WriteFile(keyFile, keyPEM, 0600)
+	return certFile, keyFile
+}
+
+// TestRedirectToHTTPS 测试 HTTP 请求重定向到 HTTPS 端口
+func TestRedirectToHTTPS(t *testing.T) {
+	tests := []struct {
+		method, host, uri, port string
+		wantStatus              int
+		wantLocation            string
+	}{
+		{"GET", "example.com:8080", "/docs/a.txt?x=1", "8443", 301, "https://example.com:8443/docs/a.txt?x=1"},
+		{"GET", "example.com", "/", "443", 301, "https://example.com/"},
+		{"POST", "[::1]:8080", "/upload", "443", 308, "https://[::1]/upload"},
+		{"PUT", "127.0.0.1:8080", "/$.dav$/a.txt", "9443", 308, "https://127.0.0.1:9443/$.dav$/a.txt"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.uri, nil)
+		req.Host = tt.host
+		w := httptest.NewRecorder()
+		redirectToHTTPS(tt.port).ServeHTTP(w, req)
+		if w.Code != tt.wantStatus || w.Header().Get("Location") != tt.wantLocation {
+			t.Errorf("%s %s%s: got %d %s, want %d %s", tt.method, tt.host, tt.uri,
+				w.Code, w.Header().Get("Location"), tt.wantStatus, tt.wantLocation)
+		}
+	}
+}
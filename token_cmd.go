@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/breezechen/go_file_server/auth"
+	"github.com/urfave/cli/v2"
+)
+
+// tokenCommand 管理 --tokens-file 指定的令牌文件
+var tokenCommand = &cli.Command{
+	Name:  "token",
+	Usage: "manage API tokens in the tokens file",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "file",
+			Aliases:  []string{"f"},
+			Usage:    "tokens file to manage",
+			Required: true,
+		},
+	},
+	Subcommands: []*cli.Command{
+		{
+			Name:      "create",
+			Usage:     "create a token and print it, the token cannot be shown again",
+			ArgsUsage: "<username>",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "name",
+					Usage: "description of the token",
+				},
+				&cli.StringSliceFlag{
+					Name:  "scope",
+					Usage: "scopes granted to the token: read, write, download-tasks",
+					Value: cli.NewStringSlice(auth.ScopeRead),
+				},
+				&cli.DurationFlag{
+					Name:  "expires",
+					Usage: "lifetime of the token, 0 for never",
+				},
+				&cli.StringFlag{
+					Name:  "users-file",
+					Usage: "refuse to create the token if the user is not in this users file",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				username := c.Args().First()
+				if username == "" {
+					return fmt.Errorf("username is required")
+				}
+				if usersFile := c.String("users-file"); usersFile != "" {
+					users, err := auth.LoadUserStore(usersFile)
+					if err != nil {
+						return err
+					}
+					if !users.Exists(username) {
+						return fmt.Errorf("%w: %s", auth.ErrUserNotFound, username)
+					}
+				}
+				token, raw, err := auth.CreateToken(c.String("file"), username, c.String("name"),
+					c.StringSlice("scope"), c.Duration("expires"))
+				if err != nil {
+					return err
+				}
+				fmt.Printf("Token %s created for user %s:\n%s\n", token.ID, username, raw)
+				return nil
+			},
+		},
+		{
+			Name:      "revoke",
+			Usage:     "revoke a token",
+			ArgsUsage: "<id>",
+			Action: func(c *cli.Context) error {
+				id := c.Args().First()
+				if id == "" {
+					return fmt.Errorf("token id is required")
+				}
+				if err := auth.RevokeToken(c.String("file"), id); err != nil {
+					return err
+				}
+				fmt.Printf("Token %s revoked\n", id)
+				return nil
+			},
+		},
+		{
+			Name:  "list",
+			Usage: "list tokens",
+			Action: func(c *cli.Context) error {
+				tokens, err := auth.LoadTokenStore(c.String("file"))
+				if err != nil {
+					return err
+				}
+				for _, token := range tokens.Tokens() {
+					expires := "never"
+					if token.ExpiresAt != nil {
+						expires = token.ExpiresAt.Format("2006-01-02 15:04:05")
+					}
+					fmt.Printf("%s\t%s\t%s\texpires %s\t%s\n", token.ID, token.User,
+						strings.Join(token.Scopes, ","), expires, token.Name)
+				}
+				return nil
+			},
+		},
+	},
+}
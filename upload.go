@@ -0,0 +1,518 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/breezechen/go_file_server/auth"
+	"github.com/breezechen/go_file_server/quota"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	uploadStoreFile = "uploads.json"
+	// 状态目录中保存未完成上传数据的子目录
+	uploadDataDir = "uploads"
+	// uploadPrefix 可续传上传的路径前缀，POST /:uploads 创建上传，之后通过 /:uploads/<上传ID> 写入、查询、完成或取消
+	uploadPrefix = "/:uploads"
+	// uploadOffsetHeader 分块的起始位置和上传已接收的字节数
+	uploadOffsetHeader = "Upload-Offset"
+	// uploadLengthHeader 上传文件的总大小
+	uploadLengthHeader = "Upload-Length"
+	// 上传超过该时间没有写入时被删除
+	defaultUploadExpiry = 24 * time.Hour
+)
+
+var (
+	// ErrUploadNotFound 上传不存在、已过期或属于其他用户
+	ErrUploadNotFound = errors.New("upload not found")
+	// ErrUploadConflict 分块的起始位置与已接收的字节数不一致，或有其它请求正在写入
+	ErrUploadConflict = errors.New("upload conflict")
+)
+
+// Upload 一个可续传的上传，数据先写入状态目录，全部接收后移动到目标位置
+type Upload struct {
+	Id string `json:"id"`
+	// 相对用户根目录的目标路径
+	Path string `json:"path"`
+	// 文件总大小
+	Size int64 `json:"size"`
+	// 已接收的字节数，续传时从这里开始
+	Offset    int64     `json:"offset"`
+	Owner     string    `json:"owner,omitempty"`
+	Sha256    string    `json:"sha256,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+
+	// 目标文件在文件系统中的完整路径
+	target string
+	// 是否有请求正在写入
+	busy bool
+}
+
+// CreateUploadRequest 创建上传的请求参数
+type CreateUploadRequest struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+}
+
+// storedUpload 是保存到文件中的上传，额外保存接口中不返回的目标路径
+type storedUpload struct {
+	*Upload
+	Target string `json:"target"`
+}
+
+// UploadManager 管理可续传的上传，设置了状态目录时未完成的上传保存在其中，重启后可以继续
+type UploadManager struct {
+	// 保存未完成上传数据的目录
+	dir string
+	// 保存上传记录的文件，为空时只保存在内存中
+	path   string
+	expiry time.Duration
+
+	mu      sync.Mutex
+	uploads map[string]*Upload
+}
+
+// NewUploadManager 创建上传管理器，stateDir 为空时数据保存在临时目录中，重启后无法续传
+func NewUploadManager(stateDir string, expiry time.Duration) (*UploadManager, error) {
+	if expiry <= 0 {
+		expiry = defaultUploadExpiry
+	}
+	m := &UploadManager{expiry: expiry, uploads: make(map[string]*Upload)}
+	if stateDir == "" {
+		dir, err := os.MkdirTemp("", "fileserver-uploads-")
+		if err != nil {
+			return nil, err
+		}
+		m.dir = dir
+		return m, nil
+	}
+
+	m.dir = filepath.Join(stateDir, uploadDataDir)
+	if err := os.MkdirAll(m.dir, 0700); err != nil {
+		return nil, err
+	}
+	m.path = filepath.Join(stateDir, uploadStoreFile)
+	data, err := os.ReadFile(m.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		var list []storedUpload
+		if err := json.Unmarshal(data, &list); err != nil {
+			return nil, err
+		}
+		for _, stored := range list {
+			if stored.Upload == nil || stored.Id == "" {
+				continue
+			}
+			// 以实际写入的数据为准，中断的写入可能没有保存到记录中
+			stat, err := os.Stat(m.partPath(stored.Id))
+			if err != nil {
+				continue
+			}
+			stored.Offset = min(stat.Size(), stored.Size)
+			stored.target = stored.Target
+			m.uploads[stored.Id] = stored.Upload
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pruneLocked()
+	m.removeOrphansLocked()
+	return m, nil
+}
+
+// partPath 返回上传数据的保存路径
+func (m *UploadManager) partPath(id string) string {
+	return filepath.Join(m.dir, id+".part")
+}
+
+// persistLocked 保存所有上传记录，调用方需持有 m.mu
+func (m *UploadManager) persistLocked() {
+	if m.path == "" {
+		return
+	}
+	list := make([]storedUpload, 0, len(m.uploads))
+	for _, upload := range m.uploads {
+		list = append(list, storedUpload{Upload: upload, Target: upload.target})
+	}
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err == nil {
+		tmpPath := m.path + ".tmp"
+		if err = os.WriteFile(tmpPath, data, 0600); err == nil {
+			err = os.Rename(tmpPath, m.path)
+		}
+	}
+	if err != nil {
+		log.Printf("failed to save uploads: %v", err)
+	}
+}
+
+// pruneLocked 删除过期的上传和它们的数据，调用方需持有 m.mu
+func (m *UploadManager) pruneLocked() {
+	now := time.Now()
+	removed := false
+	for id, upload := range m.uploads {
+		if !upload.busy && now.After(upload.ExpiresAt) {
+			delete(m.uploads, id)
+			os.Remove(m.partPath(id))
+			removed = true
+		}
+	}
+	if removed {
+		m.persistLocked()
+	}
+}
+
+// removeOrphansLocked 删除没有对应上传记录的数据文件，调用方需持有 m.mu
+func (m *UploadManager) removeOrphansLocked() {
+	entries, err := os.ReadDir(m.dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".part")
+		if _, exists := m.uploads[id]; !ok || !exists {
+			os.Remove(filepath.Join(m.dir, entry.Name()))
+		}
+	}
+}
+
+// Create 为 target（文件系统中的完整路径）创建大小为 size 的上传，uri 为相对用户根目录的路径
+func (m *UploadManager) Create(target, uri, owner string, size int64, sha256 string) (*Upload, error) {
+	if size < 0 {
+		return nil, fmt.Errorf("%w: size must not be negative", ErrBadRequest)
+	}
+	checksums, err := normalizeChecksums(map[string]string{"sha256": sha256})
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	upload := &Upload{
+		Id:        uuid.New().String(),
+		Path:      uri,
+		Size:      size,
+		Owner:     owner,
+		Sha256:    checksums["sha256"],
+		CreatedAt: now,
+		ExpiresAt: now.Add(m.expiry),
+		target:    target,
+	}
+	file, err := os.OpenFile(m.partPath(upload.Id), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pruneLocked()
+	m.uploads[upload.Id] = upload
+	m.persistLocked()
+	copied := *upload
+	return &copied, nil
+}
+
+// getLocked 返回 owner 的上传，调用方需持有 m.mu
+func (m *UploadManager) getLocked(id, owner string) (*Upload, error) {
+	upload, ok := m.uploads[id]
+	if !ok || upload.Owner != owner || (!upload.busy && time.Now().After(upload.ExpiresAt)) {
+		return nil, ErrUploadNotFound
+	}
+	return upload, nil
+}
+
+// Get 返回 owner 的上传
+func (m *UploadManager) Get(id, owner string) (*Upload, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	upload, err := m.getLocked(id, owner)
+	if err != nil {
+		return nil, err
+	}
+	copied := *upload
+	return &copied, nil
+}
+
+// Write 从 offset 开始写入一个分块，offset 必须等于已接收的字节数。返回写入后已接收的字节数，
+// 写入中断时已经收到的部分仍然保留，客户端可以从返回的位置续传
+func (m *UploadManager) Write(id, owner string, offset int64, body io.Reader) (int64, error) {
+	m.mu.Lock()
+	upload, err := m.getLocked(id, owner)
+	if err != nil {
+		m.mu.Unlock()
+		return 0, err
+	}
+	if upload.busy {
+		m.mu.Unlock()
+		return upload.Offset, fmt.Errorf("%w: another request is writing to the upload", ErrUploadConflict)
+	}
+	if offset != upload.Offset {
+		m.mu.Unlock()
+		return upload.Offset, fmt.Errorf("%w: offset %d does not match received %d bytes", ErrUploadConflict, offset, upload.Offset)
+	}
+	upload.busy = true
+	remaining := upload.Size - upload.Offset
+	m.mu.Unlock()
+
+	n, err := writeChunk(m.partPath(id), offset, body, remaining)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	upload.busy = false
+	upload.Offset += n
+	upload.ExpiresAt = time.Now().Add(m.expiry)
+	m.persistLocked()
+	return upload.Offset, err
+}
+
+// writeChunk 将 body 写入文件的 offset 位置，最多写入 remaining 字节，body 更长时返回错误
+func writeChunk(file string, offset int64, body io.Reader, remaining int64) (int64, error) {
+	f, err := os.OpenFile(file, os.O_WRONLY, 0600)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	// 丢弃上次中断时写入但没有计入的数据
+	if err := f.Truncate(offset); err != nil {
+		return 0, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	n, err := io.Copy(f, io.LimitReader(body, remaining))
+	if err != nil {
+		return n, err
+	}
+	if extra, _ := body.Read(make([]byte, 1)); extra > 0 {
+		return n, fmt.Errorf("%w: chunk exceeds the upload size", ErrBadRequest)
+	}
+	return n, nil
+}
+
+// Commit 在接收完所有数据后校验摘要并将文件移动到目标位置，返回完成的上传
+func (m *UploadManager) Commit(id, owner string) (*Upload, error) {
+	m.mu.Lock()
+	upload, err := m.getLocked(id, owner)
+	if err != nil {
+		m.mu.Unlock()
+		return nil, err
+	}
+	if upload.busy {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("%w: another request is writing to the upload", ErrUploadConflict)
+	}
+	if upload.Offset != upload.Size {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("%w: received %d of %d bytes", ErrUploadConflict, upload.Offset, upload.Size)
+	}
+	upload.busy = true
+	copied := *upload
+	m.mu.Unlock()
+
+	err = m.finish(&copied)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	upload.busy = false
+	if err != nil {
+		return nil, err
+	}
+	delete(m.uploads, id)
+	m.persistLocked()
+	return &copied, nil
+}
+
+// finish 校验上传的数据并移动到目标位置
+func (m *UploadManager) finish(upload *Upload) error {
+	part := m.partPath(upload.Id)
+	if upload.Sha256 != "" {
+		if _, err := verifyChecksums(part, map[string]string{"sha256": upload.Sha256}); err != nil {
+			if errors.Is(err, ErrChecksumMismatch) {
+				return fmt.Errorf("%w: %v", ErrBadRequest, err)
+			}
+			return err
+		}
+	}
+	return moveFile(part, upload.target)
+}
+
+// Cancel 取消上传并删除已接收的数据
+func (m *UploadManager) Cancel(id, owner string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	upload, err := m.getLocked(id, owner)
+	if err != nil {
+		return err
+	}
+	if upload.busy {
+		return fmt.Errorf("%w: another request is writing to the upload", ErrUploadConflict)
+	}
+	delete(m.uploads, id)
+	os.Remove(m.partPath(id))
+	m.persistLocked()
+	return nil
+}
+
+// moveFile 将 src 移动到 dst，不在同一文件系统时先复制到 dst 所在目录的临时文件再重命名，然后删除 src
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	err = writeFileAtomic(dst, func(w io.Writer) error {
+		_, err := io.Copy(w, in)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	in.Close()
+	return os.Remove(src)
+}
+
+// handleUploads 处理 /:uploads 下的可续传上传请求：
+//
+//	POST   /:uploads       创建上传，请求体为 CreateUploadRequest
+//	GET    /:uploads/<id>  查询上传，Upload-Offset 头为已接收的字节数
+//	PATCH  /:uploads/<id>  从 Upload-Offset 头指定的位置写入请求体
+//	POST   /:uploads/<id>  完成上传
+//	DELETE /:uploads/<id>  取消上传
+func handleUploads(c *gin.Context, authConfig *auth.AuthConfig, uploads *UploadManager, quotaManager *quota.Manager, root string) {
+	id := strings.TrimPrefix(strings.TrimPrefix(c.Request.URL.Path, uploadPrefix), "/")
+	owner := auth.UserFromRequest(c.Request)
+
+	if id == "" {
+		if c.Request.Method != http.MethodPost {
+			c.String(405, "405 method not allowed")
+			return
+		}
+		createUpload(c, authConfig, uploads, quotaManager, root)
+		return
+	}
+
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead:
+		upload, err := uploads.Get(id, owner)
+		if err != nil {
+			c.String(errorStatus(err), err.Error())
+			return
+		}
+		setUploadHeaders(c, upload.Offset, upload.Size)
+		c.JSON(200, upload)
+	case http.MethodPatch:
+		if !requireScope(c, authConfig, auth.ScopeWrite) {
+			return
+		}
+		offset, err := strconv.ParseInt(c.GetHeader(uploadOffsetHeader), 10, 64)
+		if err != nil {
+			c.String(400, "missing or invalid %s header", uploadOffsetHeader)
+			return
+		}
+		received, err := uploads.Write(id, owner, offset, c.Request.Body)
+		if errors.Is(err, ErrUploadNotFound) {
+			c.String(errorStatus(err), err.Error())
+			return
+		}
+		c.Header(uploadOffsetHeader, strconv.FormatInt(received, 10))
+		if err != nil {
+			c.String(errorStatus(err), err.Error())
+			return
+		}
+		c.Status(http.StatusNoContent)
+	case http.MethodPost:
+		upload, err := uploads.Get(id, owner)
+		if err != nil {
+			c.String(errorStatus(err), err.Error())
+			return
+		}
+		dir, name := path.Split(upload.Path)
+		setAudit(c, "upload", path.Clean(dir)).Files = []string{name}
+		if !authorizeWrite(c, authConfig, auth.ScopeWrite, upload.Path) {
+			return
+		}
+		if upload, err = uploads.Commit(id, owner); err != nil {
+			c.String(errorStatus(err), err.Error())
+			return
+		}
+		c.JSON(200, upload)
+	case http.MethodDelete:
+		if !requireScope(c, authConfig, auth.ScopeWrite) {
+			return
+		}
+		if err := uploads.Cancel(id, owner); err != nil {
+			c.String(errorStatus(err), err.Error())
+			return
+		}
+		c.Status(http.StatusNoContent)
+	default:
+		c.String(405, "405 method not allowed")
+	}
+}
+
+// createUpload 检查目标路径、权限和配额后创建上传
+func createUpload(c *gin.Context, authConfig *auth.AuthConfig, uploads *UploadManager, quotaManager *quota.Manager, root string) {
+	req := CreateUploadRequest{}
+	if err := c.BindJSON(&req); err != nil {
+		return
+	}
+	uri := path.Clean("/" + req.Path)
+	target := filepath.Join(root, filepath.FromSlash(uri))
+	if uri == "/" || !isSubDir(root, target) {
+		c.String(400, "400 bad request")
+		return
+	}
+	if stat, err := os.Stat(filepath.Dir(target)); err != nil || !stat.IsDir() {
+		c.String(404, "404 not found")
+		return
+	}
+	if stat, err := os.Stat(target); err == nil && stat.IsDir() {
+		c.String(409, "%s is a directory", uri)
+		return
+	}
+	if !authorizeWrite(c, authConfig, auth.ScopeWrite, uri) {
+		return
+	}
+
+	owner := auth.UserFromRequest(c.Request)
+	if err := quotaManager.ReserveFile(owner, target, req.Size); err != nil {
+		c.String(errorStatus(err), err.Error())
+		return
+	}
+	upload, err := uploads.Create(target, uri, owner, req.Size, req.Sha256)
+	if err != nil {
+		c.String(errorStatus(err), err.Error())
+		return
+	}
+	c.Header("Location", uploadPrefix+"/"+upload.Id)
+	setUploadHeaders(c, upload.Offset, upload.Size)
+	c.JSON(201, upload)
+}
+
+// setUploadHeaders 设置上传已接收的字节数和总大小的响应头
+func setUploadHeaders(c *gin.Context, offset, size int64) {
+	c.Header(uploadOffsetHeader, strconv.FormatInt(offset, 10))
+	c.Header(uploadLengthHeader, strconv.FormatInt(size, 10))
+}
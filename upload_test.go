@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestUploadManager 测试分块写入、续传和完成上传
+func TestUploadManager(t *testing.T) {
+	stateDir := t.TempDir()
+	target := filepath.Join(t.TempDir(), "big.bin")
+	m, err := NewUploadManager(stateDir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upload, err := m.Create(target, "/big.bin", "alice", 10, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Get(upload.Id, "bob"); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("other users should not see the upload: %v", err)
+	}
+	if offset, err := m.Write(upload.Id, "alice", 0, strings.NewReader("01234")); err != nil || offset != 5 {
+		t.Fatalf("Write() = %d, %v", offset, err)
+	}
+	if offset, err := m.Write(upload.Id, "alice", 2, strings.NewReader("xx")); !errors.Is(err, ErrUploadConflict) || offset != 5 {
+		t.Errorf("Write() at wrong offset = %d, %v", offset, err)
+	}
+	if _, err := m.Commit(upload.Id, "alice"); !errors.Is(err, ErrUploadConflict) {
+		t.Errorf("incomplete upload should not be committed: %v", err)
+	}
+
+	// 重启后从已接收的位置继续
+	m, err = NewUploadManager(stateDir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current, err := m.Get(upload.Id, "alice"); err != nil || current.Offset != 5 {
+		t.Fatalf("Get() after restart = %+v, %v", current, err)
+	}
+	if offset, err := m.Write(upload.Id, "alice", 5, strings.NewReader("56789extra")); !errors.Is(err, ErrBadRequest) || offset != 10 {
+		t.Errorf("Write() beyond the size = %d, %v", offset, err)
+	}
+	if _, err := m.Commit(upload.Id, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(target); string(data) != "0123456789" {
+		t.Errorf("committed file = %q", data)
+	}
+	if _, err := m.Get(upload.Id, "alice"); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("committed upload should be removed: %v", err)
+	}
+
+	// 摘要不一致时不移动文件
+	upload, err = m.Create(target+".2", "/big.bin.2", "", 3, strings.Repeat("0", 64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Write(upload.Id, "", 0, strings.NewReader("abc"))
+	if _, err := m.Commit(upload.Id, ""); !errors.Is(err, ErrBadRequest) {
+		t.Errorf("checksum mismatch should fail: %v", err)
+	}
+	if err := m.Cancel(upload.Id, ""); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(stateDir, uploadDataDir)); len(entries) != 0 {
+		t.Errorf("canceled upload data should be removed, got %d files", len(entries))
+	}
+}
+
+// TestHandleUploads 测试可续传上传的 HTTP 接口
+func TestHandleUploads(t *testing.T) {
+	root := t.TempDir()
+	os.Mkdir(filepath.Join(root, "docs"), 0755)
+	m, err := NewUploadManager("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(m.dir)
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		handleUploads(c, nil, m, nil, root)
+	})
+
+	do := func(method, path, body string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, body := range []string{`{"path":"/missing/a.txt","size":1}`, `{"path":"/","size":1}`, `{"path":"/docs","size":1}`} {
+		if w := do("POST", "/:uploads", body, nil); w.Code < 400 {
+			t.Errorf("POST %s should fail, got %d", body, w.Code)
+		}
+	}
+
+	w := do("POST", "/:uploads", `{"path":"/docs/a.txt","size":6}`, nil)
+	if w.Code != 201 {
+		t.Fatalf("create upload: %d %s", w.Code, w.Body.String())
+	}
+	var upload Upload
+	json.Unmarshal(w.Body.Bytes(), &upload)
+	if w.Header().Get("Location") != "/:uploads/"+upload.Id || upload.Path != "/docs/a.txt" {
+		t.Errorf("unexpected upload %+v, location %s", upload, w.Header().Get("Location"))
+	}
+
+	uploadPath := "/:uploads/" + upload.Id
+	if w := do("PATCH", uploadPath, "abc", nil); w.Code != 400 {
+		t.Errorf("PATCH without offset: %d", w.Code)
+	}
+	if w := do("PATCH", uploadPath, "abc", map[string]string{uploadOffsetHeader: "0"}); w.Code != http.StatusNoContent || w.Header().Get(uploadOffsetHeader) != "3" {
+		t.Errorf("PATCH: %d, offset %s", w.Code, w.Header().Get(uploadOffsetHeader))
+	}
+	if w := do("PATCH", uploadPath, "abc", map[string]string{uploadOffsetHeader: "0"}); w.Code != 409 || w.Header().Get(uploadOffsetHeader) != "3" {
+		t.Errorf("PATCH at wrong offset: %d, offset %s", w.Code, w.Header().Get(uploadOffsetHeader))
+	}
+	if w := do("HEAD", uploadPath, "", nil); w.Header().Get(uploadOffsetHeader) != "3" || w.Header().Get(uploadLengthHeader) != "6" {
+		t.Errorf("HEAD: %d, headers %v", w.Code, w.Header())
+	}
+	do("PATCH", uploadPath, "def", map[string]string{uploadOffsetHeader: "3"})
+	if w := do("POST", uploadPath, "", nil); w.Code != 200 {
+		t.Fatalf("commit: %d %s", w.Code, w.Body.String())
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, "docs", "a.txt")); string(data) != "abcdef" {
+		t.Errorf("uploaded file = %q", data)
+	}
+	if w := do("GET", uploadPath, "", nil); w.Code != 404 {
+		t.Errorf("committed upload should not be found: %d", w.Code)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/breezechen/go_file_server/auth"
+	"github.com/urfave/cli/v2"
+)
+
+// userCommand 管理 --users-file 指定的用户文件
+var userCommand = &cli.Command{
+	Name:  "user",
+	Usage: "manage accounts in the users file",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "file",
+			Aliases:  []string{"f"},
+			Usage:    "users file to manage",
+			Required: true,
+		},
+	},
+	Subcommands: []*cli.Command{
+		{
+			Name:      "add",
+			Usage:     "add a user",
+			ArgsUsage: "<username>",
+			Flags:     []cli.Flag{passwordFlag},
+			Action: func(c *cli.Context) error {
+				username, password, err := userArgs(c)
+				if err != nil {
+					return err
+				}
+				if err := auth.AddUser(c.String("file"), username, password); err != nil {
+					return err
+				}
+				fmt.Printf("User %s added\n", username)
+				return nil
+			},
+		},
+		{
+			Name:      "passwd",
+			Usage:     "change the password of a user, a running server signs out their sessions",
+			ArgsUsage: "<username>",
+			Flags:     []cli.Flag{passwordFlag},
+			Action: func(c *cli.Context) error {
+				username, password, err := userArgs(c)
+				if err != nil {
+					return err
+				}
+				if err := auth.SetPassword(c.String("file"), username, password); err != nil {
+					return err
+				}
+				fmt.Printf("Password of user %s changed\n", username)
+				return nil
+			},
+		},
+		{
+			Name:      "home",
+			Usage:     "set the home directory of a user, relative to the served dir; empty to use the default",
+			ArgsUsage: "<username> [dir]",
+			Action: func(c *cli.Context) error {
+				username := c.Args().First()
+				if username == "" {
+					return fmt.Errorf("username is required")
+				}
+				home := c.Args().Get(1)
+				if err := auth.SetHome(c.String("file"), username, home); err != nil {
+					return err
+				}
+				if home == "" {
+					fmt.Printf("Home directory of user %s reset to default\n", username)
+				} else {
+					fmt.Printf("Home directory of user %s set to %s\n", username, home)
+				}
+				return nil
+			},
+		},
+		{
+			Name:      "del",
+			Usage:     "delete a user, a running server signs out their sessions",
+			ArgsUsage: "<username>",
+			Action: func(c *cli.Context) error {
+				username := c.Args().First()
+				if username == "" {
+					return fmt.Errorf("username is required")
+				}
+				if err := auth.DeleteUser(c.String("file"), username); err != nil {
+					return err
+				}
+				fmt.Printf("User %s deleted\n", username)
+				return nil
+			},
+		},
+		{
+			Name:  "list",
+			Usage: "list users",
+			Action: func(c *cli.Context) error {
+				users, err := auth.LoadUserStore(c.String("file"))
+				if err != nil {
+					return err
+				}
+				for _, name := range users.Users() {
+					fmt.Println(name)
+				}
+				return nil
+			},
+		},
+	},
+}
+
+var passwordFlag = &cli.StringFlag{
+	Name:  "password",
+	Usage: "new password, read from stdin if empty",
+}
+
+// userArgs 返回命令行中的用户名和密码，未指定密码时从标准输入读取
+func userArgs(c *cli.Context) (string, string, error) {
+	username := c.Args().First()
+	if username == "" {
+		return "", "", fmt.Errorf("username is required")
+	}
+
+	password := c.String("password")
+	if password == "" {
+		fmt.Fprint(os.Stderr, "Password: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", "", fmt.Errorf("failed to read password: %w", err)
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+	if password == "" {
+		return "", "", fmt.Errorf("password must not be empty")
+	}
+	return username, password, nil
+}
@@ -18,6 +18,7 @@ type Client struct {
 	HTTPClient *http.Client
 	Username   string
 	Password   string
+	Token      string // API 令牌，设置后代替基础认证
 	Headers    map[string]string
 }
 
@@ -36,6 +37,11 @@ func (c *Client) SetAuth(username, password string) {
 	c.Password = password
 }
 
+// SetToken 设置 API 令牌，以 Authorization: Bearer 头发送
+func (c *Client) SetToken(token string) {
+	c.Token = token
+}
+
 // SetTimeout 设置超时
 func (c *Client) SetTimeout(timeout time.Duration) {
 	c.HTTPClient.Timeout = timeout
@@ -54,8 +60,10 @@ func (c *Client) makeRequest(method, path string, body io.Reader, headers map[st
 		return nil, err
 	}
 	
-	// 设置基础认证
-	if c.Username != "" && c.Password != "" {
+	// 设置认证信息
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	} else if c.Username != "" && c.Password != "" {
 		req.SetBasicAuth(c.Username, c.Password)
 	}
 	
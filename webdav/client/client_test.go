@@ -67,6 +67,28 @@ func TestSetAuth(t *testing.T) {
 	}
 }
 
+// TestSetToken 测试使用 API 令牌认证
+func TestSetToken(t *testing.T) {
+	var receivedAuth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receivedAuth = r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	client.SetAuth("user", "pass")
+	client.SetToken("abc.def")
+	resp, err := client.makeRequest("GET", "/test", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if receivedAuth != "Bearer abc.def" {
+		t.Errorf("Authorization = %q, want Bearer abc.def", receivedAuth)
+	}
+}
+
 // TestSetTimeout 测试设置超时
 func TestSetTimeout(t *testing.T) {
 	client := NewClient("http://localhost:8080/dav")
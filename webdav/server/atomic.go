@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	"golang.org/x/net/webdav"
+)
+
+// TempFilePrefix 写入中的临时文件的前缀，临时文件与目标文件在同一目录中
+const TempFilePrefix = ".fileserver-tmp-"
+
+// atomicDir 以截断方式打开文件写入（PUT）时先写入同目录下的隐藏临时文件，关闭时重命名为目标文件，
+// 其它客户端不会看到写了一半的文件，写入失败时保留原来的文件
+type atomicDir struct {
+	webdav.Dir
+}
+
+// fullPath 返回 name 在文件系统中的完整路径
+func (d atomicDir) fullPath(name string) string {
+	return filepath.Join(string(d.Dir), filepath.FromSlash(path.Clean("/"+name)))
+}
+
+func (d atomicDir) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if flag&os.O_TRUNC == 0 || flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return d.Dir.OpenFile(ctx, name, flag, perm)
+	}
+
+	full := d.fullPath(name)
+	mode := os.FileMode(0644)
+	if stat, err := os.Stat(full); err == nil {
+		if stat.IsDir() {
+			return nil, &os.PathError{Op: "open", Path: full, Err: os.ErrExist}
+		}
+		mode = stat.Mode().Perm()
+	} else if flag&os.O_CREATE == 0 {
+		return nil, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(full), TempFilePrefix+"*")
+	if err != nil {
+		return nil, err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	return &atomicFile{File: tmp, target: full}, nil
+}
+
+// atomicFile 写入临时文件，没有出错时关闭后重命名为目标文件
+type atomicFile struct {
+	*os.File
+	target string
+	// 写入或读取请求内容失败，关闭时丢弃临时文件
+	failed bool
+}
+
+func (f *atomicFile) Write(p []byte) (int, error) {
+	n, err := f.File.Write(p)
+	if err != nil {
+		f.failed = true
+	}
+	return n, err
+}
+
+// ReadFrom 供 io.Copy 使用，请求内容读取失败（例如客户端断开连接）时也视为写入失败
+func (f *atomicFile) ReadFrom(r io.Reader) (int64, error) {
+	n, err := io.Copy(struct{ io.Writer }{f}, r)
+	if err != nil {
+		f.failed = true
+	}
+	return n, err
+}
+
+func (f *atomicFile) Close() error {
+	err := f.File.Close()
+	if err == nil && !f.failed {
+		err = os.Rename(f.File.Name(), f.target)
+	}
+	if err != nil || f.failed {
+		os.Remove(f.File.Name())
+	}
+	return err
+}
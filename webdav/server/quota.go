@@ -0,0 +1,215 @@
+package server
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"golang.org/x/net/webdav"
+)
+
+// QuotaFunc 在 name（文件系统中的完整路径）增加 delta 字节之前检查配额，newFile 表示会新建文件，超过配额时返回错误
+type QuotaFunc func(name string, delta int64, newFile bool) error
+
+// quotaContextKey 请求 context 中保存配额检查的键
+type quotaContextKey struct{}
+
+// WithQuota 返回带有配额检查的 context，请求写入文件时超过配额会返回 507 Insufficient Storage
+func WithQuota(ctx context.Context, quota QuotaFunc) context.Context {
+	return context.WithValue(ctx, quotaContextKey{}, quota)
+}
+
+// quotaRequest 一个请求的配额检查状态
+type quotaRequest struct {
+	check QuotaFunc
+	// PUT 请求的内容长度，打开文件时一次预留，未知时为 -1
+	expected int64
+	// 是否有写入因超过配额被拒绝
+	exceeded atomic.Bool
+}
+
+// requestQuotaKey 请求 context 中保存 quotaRequest 的键
+type requestQuotaKey struct{}
+
+func (q *quotaRequest) reserve(name string, delta int64, newFile bool) error {
+	if err := q.check(name, delta, newFile); err != nil {
+		q.exceeded.Store(true)
+		return err
+	}
+	return nil
+}
+
+// withQuotaRequest 为带有配额检查的请求记录检查状态，并在写入被拒绝时将响应状态码改为 507
+func withQuotaRequest(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, *http.Request) {
+	check, ok := r.Context().Value(quotaContextKey{}).(QuotaFunc)
+	if !ok || check == nil {
+		return w, r
+	}
+	q := &quotaRequest{check: check, expected: -1}
+	if r.Method == http.MethodPut {
+		q.expected = r.ContentLength
+	}
+	r = r.WithContext(context.WithValue(r.Context(), requestQuotaKey{}, q))
+	return &quotaResponseWriter{ResponseWriter: w, quota: q}, r
+}
+
+// quotaResponseWriter 请求因超过配额失败时返回 507
+type quotaResponseWriter struct {
+	http.ResponseWriter
+	quota *quotaRequest
+}
+
+func (w *quotaResponseWriter) WriteHeader(code int) {
+	if code >= 400 && w.quota.exceeded.Load() {
+		code = http.StatusInsufficientStorage
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// quotaFS 在写入文件和移动文件前检查请求 context 中的配额
+type quotaFS struct {
+	atomicDir
+}
+
+func (d quotaFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	q, _ := ctx.Value(requestQuotaKey{}).(*quotaRequest)
+	if q == nil || flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) == 0 {
+		return d.atomicDir.OpenFile(ctx, name, flag, perm)
+	}
+
+	full := d.fullPath(name)
+	var size int64
+	stat, err := os.Stat(full)
+	exists := err == nil
+	if exists {
+		size = stat.Size()
+	}
+	start := size
+	if flag&os.O_TRUNC != 0 {
+		start = 0
+	}
+	// 内容长度已知时打开文件前一次检查完，避免写入一部分后才失败
+	reserved := start
+	if flag&os.O_TRUNC != 0 && q.expected > reserved {
+		reserved = q.expected
+	}
+	newFile := !exists && flag&os.O_CREATE != 0
+	if reserved != size || newFile {
+		if err := q.reserve(full, reserved-size, newFile); err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := d.atomicDir.OpenFile(ctx, name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	qf := &quotaFile{File: f, name: full, quota: q, reserved: reserved}
+	if flag&os.O_APPEND != 0 {
+		qf.offset = start
+	}
+	return qf, nil
+}
+
+func (d quotaFS) Rename(ctx context.Context, oldName, newName string) error {
+	q, _ := ctx.Value(requestQuotaKey{}).(*quotaRequest)
+	if q == nil {
+		return d.Dir.Rename(ctx, oldName, newName)
+	}
+
+	// 移动到其它目录时按新位置检查用量，文件数量不重新检查
+	oldPath, newPath := d.fullPath(oldName), d.fullPath(newName)
+	size := treeSize(oldPath)
+	q.check(oldPath, -size, false)
+	if err := q.reserve(newPath, size, false); err != nil {
+		q.check(oldPath, size, false)
+		return err
+	}
+	if err := d.Dir.Rename(ctx, oldName, newName); err != nil {
+		q.check(newPath, -size, false)
+		q.check(oldPath, size, false)
+		return err
+	}
+	return nil
+}
+
+// treeSize 返回文件或目录中所有文件的总大小
+func treeSize(name string) int64 {
+	var size int64
+	filepath.WalkDir(name, func(_ string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+// quotaFile 写入超过已预留的大小时先检查配额
+type quotaFile struct {
+	webdav.File
+	name     string
+	quota    *quotaRequest
+	offset   int64
+	reserved int64
+}
+
+func (f *quotaFile) Write(p []byte) (int, error) {
+	if err := f.reserveUpTo(f.offset + int64(len(p))); err != nil {
+		return 0, err
+	}
+	n, err := f.File.Write(p)
+	f.offset += int64(n)
+	return n, err
+}
+
+// reserveUpTo 写入到 end 位置之前预留超出已预留大小的部分
+func (f *quotaFile) reserveUpTo(end int64) error {
+	if end > f.reserved {
+		if err := f.quota.reserve(f.name, end-f.reserved, false); err != nil {
+			return err
+		}
+		f.reserved = end
+	}
+	return nil
+}
+
+// ReadFrom 在底层文件支持时交给它读取，使其能够知道请求内容读取失败
+func (f *quotaFile) ReadFrom(r io.Reader) (int64, error) {
+	rf, ok := f.File.(io.ReaderFrom)
+	if !ok {
+		return io.Copy(struct{ io.Writer }{f}, r)
+	}
+	return rf.ReadFrom(&quotaReader{file: f, r: r})
+}
+
+// quotaReader 在读出的数据交给底层文件写入之前检查配额
+type quotaReader struct {
+	file *quotaFile
+	r    io.Reader
+}
+
+func (q *quotaReader) Read(p []byte) (int, error) {
+	n, err := q.r.Read(p)
+	if n > 0 {
+		if reserveErr := q.file.reserveUpTo(q.file.offset + int64(n)); reserveErr != nil {
+			return 0, reserveErr
+		}
+		q.file.offset += int64(n)
+	}
+	return n, err
+}
+
+func (f *quotaFile) Seek(offset int64, whence int) (int64, error) {
+	pos, err := f.File.Seek(offset, whence)
+	if err == nil {
+		f.offset = pos
+	}
+	return pos, err
+}
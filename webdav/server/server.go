@@ -7,23 +7,61 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"golang.org/x/net/webdav"
 )
 
-// Handler 创建一个 WebDAV 处理器
+// Handler 创建一个 WebDAV 处理器，请求的 context 中通过 WithRoot 设置了根目录时以该目录代替 rootDir
 func NewHandler(rootDir string) http.Handler {
-	return &webdav.Handler{
-		FileSystem: webdav.Dir(rootDir),
-		LockSystem: webdav.NewMemLS(),
-		Logger: func(r *http.Request, err error) {
-			if err != nil {
-				fmt.Printf("WebDAV: %s %s - Error: %v\n", r.Method, r.URL.Path, err)
-			} else {
-				fmt.Printf("WebDAV: %s %s\n", r.Method, r.URL.Path)
-			}
-		},
+	return &rootHandler{
+		rootDir:  rootDir,
+		handlers: make(map[string]*webdav.Handler),
+	}
+}
+
+// rootContextKey 请求 context 中保存根目录的键
+type rootContextKey struct{}
+
+// WithRoot 返回带有根目录的 context，用于为每个请求（例如每个用户）指定不同的根目录
+func WithRoot(ctx context.Context, root string) context.Context {
+	return context.WithValue(ctx, rootContextKey{}, root)
+}
+
+// rootHandler 按请求的根目录分发到对应的处理器，每个根目录使用独立的锁
+type rootHandler struct {
+	rootDir string
+
+	mu       sync.Mutex
+	handlers map[string]*webdav.Handler
+}
+
+func (h *rootHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	root, ok := r.Context().Value(rootContextKey{}).(string)
+	if !ok {
+		root = h.rootDir
+	}
+
+	h.mu.Lock()
+	handler := h.handlers[root]
+	if handler == nil {
+		handler = &webdav.Handler{
+			FileSystem: quotaFS{atomicDir{webdav.Dir(root)}},
+			LockSystem: webdav.NewMemLS(),
+			Logger: func(r *http.Request, err error) {
+				if err != nil {
+					fmt.Printf("WebDAV: %s %s - Error: %v\n", r.Method, r.URL.Path, err)
+				} else {
+					fmt.Printf("WebDAV: %s %s\n", r.Method, r.URL.Path)
+				}
+			},
+		}
+		h.handlers[root] = handler
 	}
+	h.mu.Unlock()
+
+	w, r = withQuotaRequest(w, r)
+	handler.ServeHTTP(w, r)
 }
 
 // HandlerWithOptions 创建一个带选项的 WebDAV 处理器
@@ -3,6 +3,7 @@ package server
 import (
 	"bytes"
 	"encoding/xml"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -26,6 +27,129 @@ func TestNewHandler(t *testing.T) {
 	}
 }
 
+// TestNewHandlerWithRoot 测试通过 context 为请求指定根目录
+func TestNewHandlerWithRoot(t *testing.T) {
+	tmpdir := t.TempDir()
+	userDir := filepath.Join(tmpdir, "alice")
+	os.Mkdir(userDir, 0755)
+	os.WriteFile(filepath.Join(tmpdir, "root.txt"), []byte("root"), 0644)
+	os.WriteFile(filepath.Join(userDir, "alice.txt"), []byte("alice"), 0644)
+
+	handler := NewHandler(tmpdir)
+	tests := []struct {
+		root, path string
+		want       int
+	}{
+		{"", "/root.txt", http.StatusOK},
+		{userDir, "/alice.txt", http.StatusOK},
+		{userDir, "/root.txt", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		if tt.root != "" {
+			req = req.WithContext(WithRoot(req.Context(), tt.root))
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("GET %s with root %q: got status %d, want %d", tt.path, tt.root, rec.Code, tt.want)
+		}
+	}
+}
+
+// TestWithQuota 测试写入超过配额时返回 507
+func TestWithQuota(t *testing.T) {
+	tmpdir := t.TempDir()
+	var used int64
+	quota := func(name string, delta int64, newFile bool) error {
+		if used+delta > 10 {
+			return fmt.Errorf("quota exceeded: %s", name)
+		}
+		used += delta
+		return nil
+	}
+
+	handler := NewHandler(tmpdir)
+	put := func(path, body string, chunked bool) int {
+		req := httptest.NewRequest("PUT", path, strings.NewReader(body))
+		if chunked {
+			req.ContentLength = -1
+		}
+		req = req.WithContext(WithQuota(req.Context(), quota))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := put("/a.txt", "12345", false); code != http.StatusCreated {
+		t.Fatalf("PUT within quota: status = %d, want 201", code)
+	}
+	if code := put("/b.txt", "1234567890", false); code != http.StatusInsufficientStorage {
+		t.Errorf("PUT over quota: status = %d, want 507", code)
+	}
+	// 内容长度未知时在写入过程中检查
+	if code := put("/c.txt", "1234567890", true); code != http.StatusInsufficientStorage {
+		t.Errorf("chunked PUT over quota: status = %d, want 507", code)
+	}
+	// 覆盖文件只计算增加的部分
+	if code := put("/a.txt", "1234567890", false); code != http.StatusCreated {
+		t.Errorf("overwrite within quota: status = %d, want 201", code)
+	}
+	if used != 10 {
+		t.Errorf("used = %d, want 10", used)
+	}
+
+	// 没有配额检查的请求不受限制
+	req := httptest.NewRequest("PUT", "/d.txt", strings.NewReader("1234567890"))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Errorf("PUT without quota: status = %d, want 201", rec.Code)
+	}
+}
+
+// TestAtomicPut 测试 PUT 先写入临时文件，请求内容读取失败时保留原来的文件
+func TestAtomicPut(t *testing.T) {
+	tmpdir := t.TempDir()
+	target := filepath.Join(tmpdir, "a.txt")
+	if err := os.WriteFile(target, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	handler := NewHandler(tmpdir)
+
+	body := io.MultiReader(strings.NewReader("partial"), brokenReader{})
+	req := httptest.NewRequest("PUT", "/a.txt", body)
+	req.ContentLength = -1
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if data, _ := os.ReadFile(target); string(data) != "old" {
+		t.Errorf("interrupted PUT replaced the file: %q", data)
+	}
+
+	req = httptest.NewRequest("PUT", "/a.txt", strings.NewReader("new"))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code >= 300 {
+		t.Fatalf("PUT: status = %d", rec.Code)
+	}
+	if data, _ := os.ReadFile(target); string(data) != "new" {
+		t.Errorf("PUT content = %q, want new", data)
+	}
+	if stat, _ := os.Stat(target); stat.Mode().Perm() != 0600 {
+		t.Errorf("PUT should keep the file mode, got %v", stat.Mode().Perm())
+	}
+	entries, _ := os.ReadDir(tmpdir)
+	if len(entries) != 1 {
+		t.Errorf("temporary files left behind: %v", entries)
+	}
+}
+
+// brokenReader 模拟客户端断开连接
+type brokenReader struct{}
+
+func (brokenReader) Read([]byte) (int, error) {
+	return 0, io.ErrUnexpectedEOF
+}
+
 // TestNewHandlerWithOptions 测试带选项创建处理器
 func TestNewHandlerWithOptions(t *testing.T) {
 	tmpdir, err := os.MkdirTemp("", "webdav-test")